@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/truongnhatanh7/xocker/internal/container"
+	"github.com/truongnhatanh7/xocker/internal/state"
+)
+
+var (
+	execInteractive bool
+	execUser        string
+)
+
+var execCmd = &cobra.Command{
+	Use:   "exec CONTAINER COMMAND [ARG...]",
+	Short: "Run a command in a running container",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		st, err := state.Find(args[0])
+		if err != nil {
+			return err
+		}
+
+		opts := &container.ExecOptions{
+			Cmd:         args[1],
+			Args:        args[2:],
+			Interactive: execInteractive,
+		}
+		if config, err := container.LoadConfig(st.ID); err == nil {
+			opts.Env = config.Env
+			opts.WorkingDir = config.WorkingDir
+			opts.User = config.User
+		}
+		if cmd.Flags().Changed("user") {
+			opts.User = execUser
+		}
+
+		code, err := container.Exec(st, opts)
+		if err != nil {
+			return err
+		}
+		os.Exit(code)
+		return nil
+	},
+}
+
+func init() {
+	// flags after the container reference belong to the command
+	execCmd.Flags().SetInterspersed(false)
+	execCmd.Flags().BoolVarP(&execInteractive, "interactive", "i", false, "Attach a pty to the command")
+	execCmd.Flags().StringVarP(&execUser, "user", "u", "", "Username or UID (format: <name|uid>[:<group|gid>]), defaults to the container user")
+
+	rootCmd.AddCommand(execCmd)
+}
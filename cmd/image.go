@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/truongnhatanh7/xocker/internal/image"
+)
+
+var (
+	imageLoadInput string
+	imageLoadTag   string
+	imageLsFormat  string
+)
+
+var imageCmd = &cobra.Command{
+	Use:   "image",
+	Short: "Manage images",
+}
+
+var imageLoadCmd = &cobra.Command{
+	Use:   "load",
+	Short: "Load an image from an OCI image layout directory or a docker save tarball",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		images, err := image.Load(imageLoadInput, imageLoadTag)
+		if err != nil {
+			return err
+		}
+		for _, img := range images {
+			if len(img.RepoTags) == 0 {
+				fmt.Printf("Loaded image ID: %s\n", img.ID)
+				continue
+			}
+			for _, tag := range img.RepoTags {
+				fmt.Printf("Loaded image: %s\n", tag)
+			}
+		}
+		return nil
+	},
+}
+
+var imageLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List images",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		images, err := image.List()
+		if err != nil {
+			return err
+		}
+
+		switch imageLsFormat {
+		case "json":
+			if images == nil {
+				images = []*image.Image{}
+			}
+			return printJSON(images)
+		case "table":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "REPOSITORY:TAG\tIMAGE ID\tLAYERS\tCREATED\tSIZE")
+			for _, img := range images {
+				tags := strings.Join(img.RepoTags, ", ")
+				if tags == "" {
+					tags = "<none>"
+				}
+				created := ""
+				if !img.Created.IsZero() {
+					created = humanDuration(time.Since(img.Created)) + " ago"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%.1fMB\n",
+					tags, img.ShortID(), len(img.Layers), created, float64(img.Size)/(1<<20))
+			}
+			return w.Flush()
+		default:
+			return fmt.Errorf("unknown format %q, expected table or json", imageLsFormat)
+		}
+	},
+}
+
+func init() {
+	imageLoadCmd.Flags().StringVarP(&imageLoadInput, "input", "i", "", "OCI image layout directory or tar archive to load")
+	imageLoadCmd.Flags().StringVarP(&imageLoadTag, "tag", "t", "", "Name the loaded image (name[:tag])")
+	imageLoadCmd.MarkFlagRequired("input")
+
+	imageLsCmd.Flags().StringVar(&imageLsFormat, "format", "table", "Output format: table or json")
+
+	imageCmd.AddCommand(imageLoadCmd)
+	imageCmd.AddCommand(imageLsCmd)
+	rootCmd.AddCommand(imageCmd)
+}
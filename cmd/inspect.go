@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/truongnhatanh7/xocker/internal/state"
+)
+
+var inspectFormat string
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect CONTAINER [CONTAINER...]",
+	Short: "Display detailed information on one or more containers",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var states []*state.State
+		for _, ref := range args {
+			s, err := state.Find(ref)
+			if err != nil {
+				return err
+			}
+			states = append(states, s)
+		}
+
+		switch inspectFormat {
+		case "json":
+			return printJSON(states)
+		case "table":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			for i, s := range states {
+				if i > 0 {
+					fmt.Fprintln(w)
+				}
+				printInspectTable(w, s)
+			}
+			return w.Flush()
+		default:
+			return fmt.Errorf("unknown format %q, expected table or json", inspectFormat)
+		}
+	},
+}
+
+func printInspectTable(w *tabwriter.Writer, s *state.State) {
+	fmt.Fprintf(w, "ID\t%s\n", s.ID)
+	fmt.Fprintf(w, "Name\t%s\n", s.Name)
+	fmt.Fprintf(w, "Status\t%s\n", s.Status)
+	fmt.Fprintf(w, "Pid\t%d\n", s.Pid)
+	fmt.Fprintf(w, "Command\t%s\n", strings.Join(append([]string{s.Cmd}, s.Args...), " "))
+	if s.Image != "" {
+		fmt.Fprintf(w, "Image\t%s\n", s.Image)
+	} else {
+		fmt.Fprintf(w, "RootFS\t%s\n", s.RootFS)
+	}
+	fmt.Fprintf(w, "Created\t%s\n", s.Created.Format("2006-01-02 15:04:05"))
+	if !s.StartedAt.IsZero() {
+		fmt.Fprintf(w, "StartedAt\t%s\n", s.StartedAt.Format("2006-01-02 15:04:05"))
+	}
+	if s.Status == state.StatusExited {
+		fmt.Fprintf(w, "FinishedAt\t%s\n", s.FinishedAt.Format("2006-01-02 15:04:05"))
+		fmt.Fprintf(w, "ExitCode\t%d\n", s.ExitCode)
+		fmt.Fprintf(w, "OOMKilled\t%t\n", s.OOMKilled)
+	}
+	if s.Network != nil {
+		fmt.Fprintf(w, "Network\t%s\n", s.Network.Name)
+		fmt.Fprintf(w, "IP\t%s\n", s.Network.IP)
+		fmt.Fprintf(w, "Gateway\t%s\n", s.Network.Gateway)
+		if s.Network.IPv6 != "" {
+			fmt.Fprintf(w, "IPv6\t%s\n", s.Network.IPv6)
+			fmt.Fprintf(w, "GatewayV6\t%s\n", s.Network.GatewayV6)
+		}
+		fmt.Fprintf(w, "Bridge\t%s\n", s.Network.Bridge)
+		fmt.Fprintf(w, "Veth\t%s\n", s.Network.Veth)
+		if len(s.Network.Aliases) > 0 {
+			fmt.Fprintf(w, "Aliases\t%s\n", strings.Join(s.Network.Aliases, ", "))
+		}
+		if s.Network.Shaping != nil {
+			fmt.Fprintf(w, "Shaping\t%s\n", s.Network.Shaping)
+		}
+		for _, p := range s.Network.Ports {
+			fmt.Fprintf(w, "Port\t%s\n", p)
+		}
+	}
+	for _, m := range s.Mounts {
+		mode := "rw"
+		if m.ReadOnly {
+			mode = "ro"
+		}
+		source := m.Source
+		if m.Type == state.MountTypeVolume {
+			source = m.Name
+		}
+		fmt.Fprintf(w, "Mount\t%s %s:%s (%s)\n", m.Type, source, m.Target, mode)
+	}
+	if s.Cgroup != nil {
+		if s.Cgroup.Driver != "" {
+			fmt.Fprintf(w, "CgroupDriver\t%s\n", s.Cgroup.Driver)
+		}
+		fmt.Fprintf(w, "CgroupPath\t%s\n", s.Cgroup.Path)
+		if s.Cgroup.Unit != "" {
+			fmt.Fprintf(w, "CgroupUnit\t%s\n", s.Cgroup.Unit)
+		}
+	}
+}
+
+func init() {
+	inspectCmd.Flags().StringVar(&inspectFormat, "format", "json", "Output format: json or table")
+
+	rootCmd.AddCommand(inspectCmd)
+}
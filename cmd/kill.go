@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/truongnhatanh7/xocker/internal/container"
+	"github.com/truongnhatanh7/xocker/internal/state"
+)
+
+var killSignal string
+
+var killCmd = &cobra.Command{
+	Use:   "kill CONTAINER [CONTAINER...]",
+	Short: "Send a signal to one or more running containers",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sig, err := container.ParseSignal(killSignal)
+		if err != nil {
+			return err
+		}
+
+		for _, ref := range args {
+			st, err := state.Find(ref)
+			if err != nil {
+				return err
+			}
+			if err := container.Kill(st, sig); err != nil {
+				return err
+			}
+			fmt.Println(ref)
+		}
+		return nil
+	},
+}
+
+func init() {
+	killCmd.Flags().StringVarP(&killSignal, "signal", "s", "KILL", "Signal to send to the container")
+
+	rootCmd.AddCommand(killCmd)
+}
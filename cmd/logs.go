@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/truongnhatanh7/xocker/internal/logs"
+	"github.com/truongnhatanh7/xocker/internal/state"
+)
+
+var (
+	logsFollow     bool
+	logsTail       string
+	logsSince      string
+	logsTimestamps bool
+)
+
+var logsCmd = &cobra.Command{
+	Use:   "logs CONTAINER",
+	Short: "Fetch the logs of a container",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		st, err := state.Find(args[0])
+		if err != nil {
+			return err
+		}
+		if st.LogPath == "" {
+			return fmt.Errorf("container %s has no log", st.Name)
+		}
+
+		tail := -1
+		if logsTail != "all" {
+			tail, err = strconv.Atoi(logsTail)
+			if err != nil || tail < 0 {
+				return fmt.Errorf("invalid --tail value %q, expected a number or all", logsTail)
+			}
+		}
+
+		since, err := logs.ParseSince(logsSince)
+		if err != nil {
+			return err
+		}
+
+		opts := logs.ReadOptions{
+			Tail:   tail,
+			Since:  since,
+			Follow: logsFollow,
+			Done: func() bool {
+				latest, err := state.Load(st.ID)
+				return err != nil || latest.Status != state.StatusRunning
+			},
+		}
+
+		return logs.Read(st.LogPath, opts, func(e *logs.Entry) error {
+			out := os.Stdout
+			if e.Stream == "stderr" {
+				out = os.Stderr
+			}
+			if logsTimestamps {
+				fmt.Fprint(out, e.Time.Format(time.RFC3339Nano), " ")
+			}
+			_, err := fmt.Fprint(out, e.Log)
+			return err
+		})
+	},
+}
+
+func init() {
+	logsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "Follow log output")
+	logsCmd.Flags().StringVarP(&logsTail, "tail", "n", "all", "Number of lines to show from the end of the logs")
+	logsCmd.Flags().StringVar(&logsSince, "since", "", "Show logs since timestamp (e.g. 2024-01-02T13:23:37Z) or relative (e.g. 42m)")
+	logsCmd.Flags().BoolVarP(&logsTimestamps, "timestamps", "t", false, "Show timestamps")
+
+	rootCmd.AddCommand(logsCmd)
+}
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/truongnhatanh7/xocker/internal/network"
+	"github.com/truongnhatanh7/xocker/internal/state"
+)
+
+var (
+	networkSubnet      string
+	networkGateway     string
+	networkIPv6Subnet  string
+	networkIPv6Gateway string
+	networkIPMasq      bool
+	networkLsFormat    string
+)
+
+var networkCmd = &cobra.Command{
+	Use:   "network",
+	Short: "Manage networks",
+}
+
+var networkCreateCmd = &cobra.Command{
+	Use:   "create [flags] NETWORK",
+	Short: "Create a bridge network",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		n, err := network.Create(network.CreateOptions{
+			Name:      args[0],
+			Subnet:    networkSubnet,
+			Gateway:   networkGateway,
+			SubnetV6:  networkIPv6Subnet,
+			GatewayV6: networkIPv6Gateway,
+			IPMasq:    networkIPMasq,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println(n.ID)
+		return nil
+	},
+}
+
+var networkLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List networks",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := network.EnsureDefault(); err != nil {
+			return err
+		}
+		networks, err := network.List()
+		if err != nil {
+			return err
+		}
+
+		switch networkLsFormat {
+		case "json":
+			if networks == nil {
+				networks = []*network.Network{}
+			}
+			return printJSON(networks)
+		case "table":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "NETWORK ID\tNAME\tDRIVER\tBRIDGE\tSUBNET\tGATEWAY")
+			for _, n := range networks {
+				subnet, gateway := n.Subnet, n.Gateway
+				if n.HasIPv6() {
+					subnet += "," + n.SubnetV6
+					gateway += "," + n.GatewayV6
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+					state.ShortID(n.ID), n.Name, n.Driver, n.Bridge, subnet, gateway)
+			}
+			return w.Flush()
+		default:
+			return fmt.Errorf("unknown format %q, expected table or json", networkLsFormat)
+		}
+	},
+}
+
+var networkRmCmd = &cobra.Command{
+	Use:   "rm NETWORK [NETWORK...]",
+	Short: "Remove one or more networks",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		for _, name := range args {
+			if err := network.Remove(name); err != nil {
+				return err
+			}
+			fmt.Println(name)
+		}
+		return nil
+	},
+}
+
+type networkEndpoint struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+	IPv6 string `json:"ipv6,omitempty"`
+}
+
+type networkDetails struct {
+	*network.Network
+	Containers []networkEndpoint `json:"containers"`
+}
+
+var networkInspectCmd = &cobra.Command{
+	Use:   "inspect NETWORK [NETWORK...]",
+	Short: "Display detailed information on one or more networks",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var details []networkDetails
+		for _, name := range args {
+			n, err := network.Get(name)
+			if err != nil {
+				return err
+			}
+			attached, err := network.Containers(n.Name)
+			if err != nil {
+				return err
+			}
+
+			d := networkDetails{Network: n, Containers: []networkEndpoint{}}
+			for _, s := range attached {
+				d.Containers = append(d.Containers, networkEndpoint{ID: s.ID, Name: s.Name, IP: s.Network.IP, IPv6: s.Network.IPv6})
+			}
+			details = append(details, d)
+		}
+		return printJSON(details)
+	},
+}
+
+// started by `run` for user-defined networks, serves until the network is idle
+var networkDNSServerCmd = &cobra.Command{
+	Use:    "dns-server NETWORK",
+	Short:  "Run the embedded DNS server of a network",
+	Hidden: true,
+	Args:   cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return network.RunDNSServer(args[0])
+	},
+}
+
+func init() {
+	networkCreateCmd.Flags().StringVar(&networkSubnet, "subnet", "", "Subnet in CIDR format (e.g. 10.10.0.0/24)")
+	networkCreateCmd.Flags().StringVar(&networkGateway, "gateway", "", "Gateway of the subnet, its first address if empty")
+	networkCreateCmd.Flags().StringVar(&networkIPv6Subnet, "ipv6-subnet", "", "IPv6 subnet in CIDR format making the network dual-stack (e.g. fd00:10::/64)")
+	networkCreateCmd.Flags().StringVar(&networkIPv6Gateway, "ipv6-gateway", "", "Gateway of the IPv6 subnet, its first address if empty")
+	networkCreateCmd.Flags().BoolVar(&networkIPMasq, "ip-masq", true, "Masquerade outbound traffic of the network (--ip-masq=false to opt out)")
+	networkLsCmd.Flags().StringVar(&networkLsFormat, "format", "table", "Output format: table or json")
+
+	networkCmd.AddCommand(networkCreateCmd)
+	networkCmd.AddCommand(networkLsCmd)
+	networkCmd.AddCommand(networkRmCmd)
+	networkCmd.AddCommand(networkInspectCmd)
+	networkCmd.AddCommand(networkDNSServerCmd)
+	rootCmd.AddCommand(networkCmd)
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/truongnhatanh7/xocker/internal/state"
+)
+
+var (
+	psAll    bool
+	psFormat string
+)
+
+var psCmd = &cobra.Command{
+	Use:   "ps",
+	Short: "List containers",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		states, err := state.List()
+		if err != nil {
+			return fmt.Errorf("failed to list containers: %w", err)
+		}
+
+		var shown []*state.State
+		for _, s := range states {
+			if psAll || s.Status == state.StatusRunning {
+				shown = append(shown, s)
+			}
+		}
+
+		switch psFormat {
+		case "json":
+			if shown == nil {
+				shown = []*state.State{}
+			}
+			return printJSON(shown)
+		case "table":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "CONTAINER ID\tNAME\tIMAGE\tCOMMAND\tCREATED\tSTATUS\tPID\tIP")
+			for _, s := range shown {
+				ip := ""
+				if s.Network != nil {
+					ip = s.Network.IP
+				}
+				img := s.Image
+				if img == "" {
+					img = s.RootFS
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%q\t%s\t%s\t%d\t%s\n",
+					state.ShortID(s.ID),
+					s.Name,
+					img,
+					strings.Join(append([]string{s.Cmd}, s.Args...), " "),
+					humanDuration(time.Since(s.Created))+" ago",
+					statusString(s),
+					s.Pid,
+					ip,
+				)
+			}
+			return w.Flush()
+		default:
+			return fmt.Errorf("unknown format %q, expected table or json", psFormat)
+		}
+	},
+}
+
+func statusString(s *state.State) string {
+	switch s.Status {
+	case state.StatusRunning:
+		return "Up " + humanDuration(time.Since(s.StartedAt))
+	case state.StatusExited:
+		return fmt.Sprintf("Exited (%d) %s ago", s.ExitCode, humanDuration(time.Since(s.FinishedAt)))
+	default:
+		return s.Status
+	}
+}
+
+func humanDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%d seconds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%d minutes", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%d days", int(d.Hours()/24))
+	}
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func init() {
+	psCmd.Flags().BoolVarP(&psAll, "all", "a", false, "Show all containers (default shows just running)")
+	psCmd.Flags().StringVar(&psFormat, "format", "table", "Output format: table or json")
+
+	rootCmd.AddCommand(psCmd)
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/truongnhatanh7/xocker/internal/container"
+	"github.com/truongnhatanh7/xocker/internal/state"
+)
+
+var rmForce bool
+
+var rmCmd = &cobra.Command{
+	Use:   "rm CONTAINER [CONTAINER...]",
+	Short: "Remove one or more containers",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		for _, ref := range args {
+			st, err := state.Find(ref)
+			if err != nil {
+				return err
+			}
+			if err := container.Remove(st, rmForce); err != nil {
+				return err
+			}
+			fmt.Println(ref)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rmCmd.Flags().BoolVarP(&rmForce, "force", "f", false, "Kill and remove a running container")
+
+	rootCmd.AddCommand(rmCmd)
+}
@@ -13,6 +13,9 @@ var logLevel string
 
 var rootCmd = &cobra.Command{
 	Use: "xocker",
+	// errors are logged by Execute, usage is only noise for runtime failures
+	SilenceUsage:  true,
+	SilenceErrors: true,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 		return logger.Init(logLevel)
 	},
@@ -3,69 +3,432 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"path/filepath"
+	"time"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/pflag"
 	"github.com/truongnhatanh7/xocker/internal/cgroupv2"
 	"github.com/truongnhatanh7/xocker/internal/common"
 	"github.com/truongnhatanh7/xocker/internal/container"
+	"github.com/truongnhatanh7/xocker/internal/image"
 	"github.com/truongnhatanh7/xocker/internal/logger"
+	"github.com/truongnhatanh7/xocker/internal/network"
+	"github.com/truongnhatanh7/xocker/internal/state"
 	"go.uber.org/zap"
 )
 
 var (
+	name        string
 	rootfs      string
 	interactive bool
-	cpu         uint64
-	mem         uint64
+	detach      bool
+	logMaxSize  string
+	logMaxFile  int
+	// process overrides of the image config
+	entrypointFlag string
+	envs           []string
+	workdir        string
+	user           string
+	volumes        []string
+	mounts         []string
+	publish        []string
+	networkName    string
+	networkAliases []string
+	ingressRate    string
+	egressRate     string
+	networkLatency time.Duration
+	networkLoss    float64
+	hostname       string
+	addHosts       []string
+	dns            []string
+	dnsSearch      []string
+	dnsOptions     []string
+	cgroupDriver   string
+	cpu            uint64
+	mem            uint64
+	pidsLimit      int64
+	cpus           float64
+	cpuShares      uint64
+	cpusetCpus     string
+	cpusetMems     string
+
+	// memory limits besides --mem
+	memorySwap        string
+	memoryReservation string
+	oomKillDisable    bool
+
+	// block I/O limits
+	blkioWeight     uint16
+	deviceReadBps   []string
+	deviceWriteBps  []string
+	deviceReadIOPS  []string
+	deviceWriteIOPS []string
 )
 
 var runCmd = &cobra.Command{
-	Use:  "run",
+	Use:  "run [flags] [IMAGE] -- [COMMAND] [ARG...]",
 	Args: cobra.ArbitraryArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		command := args[0]
-		commandArgs := args[1:]
+		if id := os.Getenv("_CONTAINER_ID"); id != "" {
+			// re-executed as shim or container child, the CLI already resolved everything
+			c, err := container.LoadConfig(id)
+			if err != nil {
+				logger.Log.Error("failed to load container config", zap.String("id", id), zap.Error(err))
+				os.Exit(1)
+			}
+			if err := container.RunContainer(c); err != nil {
+				logger.Log.Error("run container failed", zap.Error(err))
+				os.Exit(1)
+			}
+			return
+		}
+
+		// without --rootfs the first argument is the image to run
+		var img *image.Image
+		var imageName string
+		var imageConfig image.ImageConfig
+		if rootfs == "" {
+			if len(args) == 0 {
+				logger.Log.Error("either --rootfs or an image is required")
+				os.Exit(1)
+			}
+			var err error
+			imageName = args[0]
+			img, err = image.Get(imageName)
+			if err != nil {
+				logger.Log.Error("failed to resolve image", zap.Error(err))
+				os.Exit(1)
+			}
+			imageConfig = img.Config
+			args = args[1:]
+		}
+
+		argv := buildArgv(cmd, imageConfig, args)
+		if len(argv) == 0 {
+			logger.Log.Error("no command specified")
+			os.Exit(1)
+		}
+		command := argv[0]
+		commandArgs := argv[1:]
 
 		logger.Log.Debug("rootfs", zap.String("rootfs", rootfs))
 		logger.Log.Debug("interactive", zap.Bool("interactive", interactive))
 		logger.Log.Debug("command", zap.String("command", command))
 		logger.Log.Debug("commandArgs", zap.Strings("commandArgs", commandArgs))
 
-		// process rootfs dir, "." doesn't work in some cases -> resolve to full path
-		var flags []string
-
-		cmd.Flags().Visit(func(f *pflag.Flag) {
-			if f.Name == "rootfs" {
-				absRootFS, err := filepath.Abs(f.Value.String())
-				common.Must(err)
-				flags = append(flags, fmt.Sprintf("--%s=%s", f.Name, absRootFS))
-				return
-			}
-			flags = append(flags, fmt.Sprintf("--%s=%s", f.Name, f.Value.String()))
-		})
-
-		if err := container.RunContainer(&container.Container{
-			Cmd:         command,
-			Args:        commandArgs,
-			RootFS:      rootfs,
-			Flags:       flags,
-			Interactive: interactive,
-			CPUQuota:    cpu,
-			Mem:         mem,
-		}); err != nil {
+		if detach && interactive {
+			logger.Log.Error("--detach and --interactive cannot be used together")
+			os.Exit(1)
+		}
+
+		var maxSize uint64
+		if logMaxSize != "" {
+			var err error
+			maxSize, err = common.ParseBytes(logMaxSize)
+			if err != nil {
+				logger.Log.Error("invalid --log-max-size", zap.Error(err))
+				os.Exit(1)
+			}
+		}
+
+		env, err := container.MergeEnv(imageConfig.Env, envs)
+		if err != nil {
+			logger.Log.Error("invalid --env", zap.Error(err))
+			os.Exit(1)
+		}
+
+		var volumeSpecs, mountSpecs []state.Mount
+		for _, v := range volumes {
+			m, err := container.ParseVolumeFlag(v)
+			if err != nil {
+				logger.Log.Error("invalid --volume", zap.Error(err))
+				os.Exit(1)
+			}
+			volumeSpecs = append(volumeSpecs, m)
+		}
+		for _, v := range mounts {
+			m, err := container.ParseMountFlag(v)
+			if err != nil {
+				logger.Log.Error("invalid --mount", zap.Error(err))
+				os.Exit(1)
+			}
+			mountSpecs = append(mountSpecs, m)
+		}
+		// like docker, -v creates a missing host dir while --mount refuses it
+		resolvedMounts, err := container.ResolveMounts(volumeSpecs, true)
+		if err != nil {
+			logger.Log.Error("failed to resolve volumes", zap.Error(err))
+			os.Exit(1)
+		}
+		more, err := container.ResolveMounts(mountSpecs, false)
+		if err != nil {
+			logger.Log.Error("failed to resolve mounts", zap.Error(err))
+			os.Exit(1)
+		}
+		resolvedMounts = append(resolvedMounts, more...)
+
+		var ports []state.PortMapping
+		for _, p := range publish {
+			pm, err := network.ParsePortMapping(p)
+			if err != nil {
+				logger.Log.Error("invalid --publish", zap.Error(err))
+				os.Exit(1)
+			}
+			ports = append(ports, pm)
+		}
+
+		var shaping state.LinkShaping
+		if ingressRate != "" {
+			if shaping.IngressRate, err = common.ParseRate(ingressRate); err != nil {
+				logger.Log.Error("invalid --network-ingress-rate", zap.Error(err))
+				os.Exit(1)
+			}
+		}
+		if egressRate != "" {
+			if shaping.EgressRate, err = common.ParseRate(egressRate); err != nil {
+				logger.Log.Error("invalid --network-egress-rate", zap.Error(err))
+				os.Exit(1)
+			}
+		}
+		if networkLatency < 0 {
+			logger.Log.Error("invalid --network-latency, must not be negative")
+			os.Exit(1)
+		}
+		if networkLoss < 0 || networkLoss > 100 {
+			logger.Log.Error("invalid --network-loss, expected a percentage between 0 and 100")
 			os.Exit(1)
 		}
+		shaping.Latency = networkLatency
+		shaping.Loss = networkLoss
+
+		if cgroupDriver != "" && cgroupDriver != cgroupv2.DriverSystemd && cgroupDriver != cgroupv2.DriverCgroupfs {
+			logger.Log.Error("invalid --cgroup-driver, expected systemd or cgroupfs", zap.String("driver", cgroupDriver))
+			os.Exit(1)
+		}
+
+		cpuQuota := cpu
+		if cmd.Flags().Changed("cpus") {
+			if cmd.Flags().Changed("cpu") {
+				logger.Log.Error("--cpus and --cpu cannot be used together")
+				os.Exit(1)
+			}
+			if cpuQuota, err = cgroupv2.CPUsQuota(cpus); err != nil {
+				logger.Log.Error("invalid --cpus", zap.Error(err))
+				os.Exit(1)
+			}
+		}
+		if err := cgroupv2.ValidateCPUShares(cpuShares); err != nil {
+			logger.Log.Error("invalid --cpu-shares", zap.Error(err))
+			os.Exit(1)
+		}
+		if cpusetCpus != "" {
+			if err := cgroupv2.ValidateCpusetCpus(cpusetCpus); err != nil {
+				logger.Log.Error("invalid --cpuset-cpus", zap.Error(err))
+				os.Exit(1)
+			}
+		}
+		if cpusetMems != "" {
+			if err := cgroupv2.ValidateCpusetMems(cpusetMems); err != nil {
+				logger.Log.Error("invalid --cpuset-mems", zap.Error(err))
+				os.Exit(1)
+			}
+		}
+
+		memSpec := cgroupv2.MemSpec{Limit: mem, OOMKillDisable: oomKillDisable}
+		if memorySwap == "-1" {
+			memSpec.Swap = -1
+		} else if memorySwap != "" {
+			swap, err := common.ParseBytes(memorySwap)
+			if err != nil {
+				logger.Log.Error("invalid --memory-swap", zap.Error(err))
+				os.Exit(1)
+			}
+			memSpec.Swap = int64(swap)
+		}
+		if memoryReservation != "" {
+			if memSpec.Reservation, err = common.ParseBytes(memoryReservation); err != nil {
+				logger.Log.Error("invalid --memory-reservation", zap.Error(err))
+				os.Exit(1)
+			}
+		}
+		if err := memSpec.Validate(); err != nil {
+			logger.Log.Error("invalid memory limits", zap.Error(err))
+			os.Exit(1)
+		}
+
+		if err := cgroupv2.ValidateBlkioWeight(blkioWeight); err != nil {
+			logger.Log.Error("invalid --blkio-weight", zap.Error(err))
+			os.Exit(1)
+		}
+		readBps := parseDeviceLimits("device-read-bps", deviceReadBps, true)
+		writeBps := parseDeviceLimits("device-write-bps", deviceWriteBps, true)
+		readIOPS := parseDeviceLimits("device-read-iops", deviceReadIOPS, false)
+		writeIOPS := parseDeviceLimits("device-write-iops", deviceWriteIOPS, false)
+
+		if hostname != "" {
+			if err := container.ValidateHostname(hostname); err != nil {
+				logger.Log.Error("invalid --hostname", zap.Error(err))
+				os.Exit(1)
+			}
+		}
+		for _, ip := range dns {
+			if err := container.ValidateDNS(ip); err != nil {
+				logger.Log.Error("invalid --dns", zap.Error(err))
+				os.Exit(1)
+			}
+		}
+		var extraHosts []string
+		for _, h := range addHosts {
+			extraHost, err := container.ParseExtraHost(h)
+			if err != nil {
+				logger.Log.Error("invalid --add-host", zap.Error(err))
+				os.Exit(1)
+			}
+			extraHosts = append(extraHosts, extraHost)
+		}
+
+		c := &container.Container{
+			Name:           name,
+			Cmd:            command,
+			Args:           commandArgs,
+			RootFS:         rootfs,
+			Env:            env,
+			WorkingDir:     firstNonEmpty(workdir, imageConfig.WorkingDir),
+			User:           firstNonEmpty(user, imageConfig.User),
+			Mounts:         resolvedMounts,
+			Ports:          ports,
+			Network:        networkName,
+			NetworkAliases: networkAliases,
+			Shaping:        shaping,
+			Hostname:       hostname,
+			ExtraHosts:     extraHosts,
+			DNS:            dns,
+			DNSSearch:      dnsSearch,
+			DNSOptions:     dnsOptions,
+			Interactive:    interactive,
+			Detach:         detach,
+			LogMaxSize:     int64(maxSize),
+			LogMaxFile:     logMaxFile,
+			CgroupDriver:   cgroupDriver,
+			CPUQuota:       cpuQuota,
+			CPUShares:      cpuShares,
+			CpusetCpus:     cpusetCpus,
+			CpusetMems:     cpusetMems,
+			Mem:            mem,
+			PidsLimit:      pidsLimit,
+
+			MemorySwap:        memSpec.Swap,
+			MemoryReservation: memSpec.Reservation,
+			OOMKillDisable:    memSpec.OOMKillDisable,
+
+			BlkioWeight:     blkioWeight,
+			DeviceReadBps:   readBps,
+			DeviceWriteBps:  writeBps,
+			DeviceReadIOPS:  readIOPS,
+			DeviceWriteIOPS: writeIOPS,
+		}
+		if img != nil {
+			c.Image = imageName
+			c.ImageID = img.ID
+			c.LowerDirs = img.LowerDirs()
+		}
+
+		if err := container.RunContainer(c); err != nil {
+			logger.Log.Error("run container failed", zap.Error(err))
+			os.Exit(1)
+		}
+
+		if detach {
+			fmt.Println(c.ID)
+		}
 	},
 }
 
+// buildArgv follows docker: --entrypoint replaces the image entrypoint and
+// drops the image cmd, command line args replace the image cmd
+func buildArgv(cmd *cobra.Command, config image.ImageConfig, args []string) []string {
+	entrypoint := config.Entrypoint
+	cmdArgs := config.Cmd
+
+	if cmd.Flags().Changed("entrypoint") {
+		entrypoint = nil
+		if entrypointFlag != "" {
+			entrypoint = []string{entrypointFlag}
+		}
+		cmdArgs = nil
+	}
+	if len(args) > 0 {
+		cmdArgs = args
+	}
+
+	argv := append([]string{}, entrypoint...)
+	return append(argv, cmdArgs...)
+}
+
+// parseDeviceLimits parses the values of a --device-* flag or exits
+func parseDeviceLimits(flag string, specs []string, bytes bool) []cgroupv2.DeviceLimit {
+	var limits []cgroupv2.DeviceLimit
+	for _, spec := range specs {
+		limit, err := cgroupv2.ParseDeviceLimit(spec, bytes)
+		if err != nil {
+			logger.Log.Error("invalid --"+flag, zap.Error(err))
+			os.Exit(1)
+		}
+		limits = append(limits, limit)
+	}
+	return limits
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
 func init() {
+	runCmd.Flags().StringVar(&name, "name", "", "Assign a name to the container")
 	runCmd.Flags().StringVar(&rootfs, "rootfs", "", "Path to the root filesystem")
 	// for simplicity: handle both stdin and tty, instead of 2 flags -i and -t
 	runCmd.Flags().BoolVarP(&interactive, "interactive", "i", false, "Interactive mode")
-	runCmd.Flags().Uint64VarP(&cpu, "cpu", "c", cgroupv2.HALF_CPU_QUOTA, "CPU quota (CPUQuotaPerSecUSec)")
+	runCmd.Flags().BoolVarP(&detach, "detach", "d", false, "Run container in background and print container ID")
+	runCmd.Flags().StringVar(&logMaxSize, "log-max-size", "", "Rotate the container log once it reaches this size (e.g. 10m), unlimited if empty")
+	runCmd.Flags().IntVar(&logMaxFile, "log-max-file", 1, "Number of rotated container log files to keep")
+	runCmd.Flags().StringVar(&entrypointFlag, "entrypoint", "", "Overwrite the default entrypoint of the image")
+	runCmd.Flags().StringArrayVarP(&envs, "env", "e", nil, "Set environment variables (KEY=VALUE, or KEY to pass it from the host)")
+	runCmd.Flags().StringVarP(&workdir, "workdir", "w", "", "Working directory inside the container")
+	runCmd.Flags().StringVarP(&user, "user", "u", "", "Username or UID (format: <name|uid>[:<group|gid>])")
+	runCmd.Flags().StringArrayVarP(&volumes, "volume", "v", nil, "Bind mount a host path or named volume (host:container[:ro])")
+	runCmd.Flags().StringArrayVar(&mounts, "mount", nil, "Attach a mount (type=bind|volume|tmpfs,source=..,target=..[,readonly])")
+	runCmd.Flags().StringArrayVarP(&publish, "publish", "p", nil, "Publish a container port to the host ([hostIP:]hostPort:containerPort[/tcp|udp])")
+	runCmd.Flags().StringVar(&networkName, "network", network.DefaultNetwork, "Connect the container to a network: a network name, none, host or container:<name|id>")
+	runCmd.Flags().StringArrayVar(&networkAliases, "network-alias", nil, "Add a name resolved by the embedded DNS of the network")
+	runCmd.Flags().StringVar(&ingressRate, "network-ingress-rate", "", "Limit the traffic towards the container (e.g. 10mbit, 1mbps)")
+	runCmd.Flags().StringVar(&egressRate, "network-egress-rate", "", "Limit the traffic from the container (e.g. 10mbit, 1mbps)")
+	runCmd.Flags().DurationVar(&networkLatency, "network-latency", 0, "Delay the packets towards the container (e.g. 50ms)")
+	runCmd.Flags().Float64Var(&networkLoss, "network-loss", 0, "Drop this percentage of the packets towards the container")
+	runCmd.Flags().StringVar(&hostname, "hostname", "", "Container hostname, the short container ID if empty")
+	runCmd.Flags().StringArrayVar(&addHosts, "add-host", nil, "Add a custom host-to-IP mapping (host:ip)")
+	runCmd.Flags().StringArrayVar(&dns, "dns", nil, "Set custom DNS servers")
+	runCmd.Flags().StringArrayVar(&dnsSearch, "dns-search", nil, "Set custom DNS search domains")
+	runCmd.Flags().StringArrayVar(&dnsOptions, "dns-option", nil, "Set DNS options")
+	runCmd.Flags().StringVar(&cgroupDriver, "cgroup-driver", "", "Cgroup driver: systemd or cgroupfs, systemd if the host runs it")
+	runCmd.Flags().Uint64VarP(&cpu, "cpu", "c", cgroupv2.HALF_CPU_QUOTA, "CPU quota (CPUQuotaPerSecUSec), prefer --cpus")
+	runCmd.Flags().Float64Var(&cpus, "cpus", 0, "Number of CPUs, fractions allowed (e.g. 1.5), replaces --cpu")
+	runCmd.Flags().Uint64Var(&cpuShares, "cpu-shares", 0, "CPU shares, relative weight to other containers (2-262144)")
+	runCmd.Flags().StringVar(&cpusetCpus, "cpuset-cpus", "", "CPUs in which to allow execution (e.g. 0-3, 0,1)")
+	runCmd.Flags().StringVar(&cpusetMems, "cpuset-mems", "", "Memory nodes in which to allow execution (e.g. 0-3, 0,1)")
 	runCmd.Flags().Uint64VarP(&mem, "mem", "m", 128, "Mem limit")
+	runCmd.Flags().StringVar(&memorySwap, "memory-swap", "", "Memory plus swap limit (e.g. 256mb), -1 for unlimited swap")
+	runCmd.Flags().StringVar(&memoryReservation, "memory-reservation", "", "Memory protected from reclaim, soft limit (e.g. 64mb)")
+	runCmd.Flags().BoolVar(&oomKillDisable, "oom-kill-disable", false, "Throttle the container at the memory limit instead of OOM killing it")
+	runCmd.Flags().Int64Var(&pidsLimit, "pids-limit", 0, "Max number of processes in the container, unlimited if <= 0")
+	runCmd.Flags().Uint16Var(&blkioWeight, "blkio-weight", 0, "Block I/O weight, relative to other containers (10-1000)")
+	runCmd.Flags().StringArrayVar(&deviceReadBps, "device-read-bps", nil, "Limit the read rate from a device (e.g. /dev/sda:1mb)")
+	runCmd.Flags().StringArrayVar(&deviceWriteBps, "device-write-bps", nil, "Limit the write rate to a device (e.g. /dev/sda:1mb)")
+	runCmd.Flags().StringArrayVar(&deviceReadIOPS, "device-read-iops", nil, "Limit the read operations per second from a device (e.g. /dev/sda:1000)")
+	runCmd.Flags().StringArrayVar(&deviceWriteIOPS, "device-write-iops", nil, "Limit the write operations per second to a device (e.g. /dev/sda:1000)")
 
 	rootCmd.AddCommand(runCmd)
 }
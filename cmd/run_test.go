@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/truongnhatanh7/xocker/internal/image"
+)
+
+func TestBuildArgv(t *testing.T) {
+	config := image.ImageConfig{
+		Entrypoint: []string{"/docker-entrypoint.sh"},
+		Cmd:        []string{"nginx", "-g", "daemon off;"},
+	}
+	tests := []struct {
+		name       string
+		config     image.ImageConfig
+		entrypoint *string // nil when --entrypoint is not given
+		args       []string
+		want       []string
+	}{
+		{"image defaults", config, nil, nil, []string{"/docker-entrypoint.sh", "nginx", "-g", "daemon off;"}},
+		{"args replace cmd", config, nil, []string{"sh"}, []string{"/docker-entrypoint.sh", "sh"}},
+		{"entrypoint drops the image cmd", config, ptr("/bin/sh"), nil, []string{"/bin/sh"}},
+		{"entrypoint and args", config, ptr("/bin/sh"), []string{"-c", "id"}, []string{"/bin/sh", "-c", "id"}},
+		{"empty entrypoint resets it", config, ptr(""), []string{"id"}, []string{"id"}},
+		{"rootfs without image config", image.ImageConfig{}, nil, []string{"/bin/true"}, []string{"/bin/true"}},
+		{"nothing to run", image.ImageConfig{}, nil, nil, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			cmd.Flags().StringVar(&entrypointFlag, "entrypoint", "", "")
+			if tt.entrypoint != nil {
+				if err := cmd.Flags().Set("entrypoint", *tt.entrypoint); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := buildArgv(cmd, tt.config, tt.args); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("buildArgv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
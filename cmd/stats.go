@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/truongnhatanh7/xocker/internal/cgroupv2"
+	"github.com/truongnhatanh7/xocker/internal/container"
+	"github.com/truongnhatanh7/xocker/internal/state"
+)
+
+var statsFormat string
+
+type containerStats struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	*cgroupv2.Stats
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats [CONTAINER...]",
+	Short: "Display resource usage of running containers",
+	Args:  cobra.ArbitraryArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var states []*state.State
+		if len(args) == 0 {
+			all, err := state.List()
+			if err != nil {
+				return fmt.Errorf("failed to list containers: %w", err)
+			}
+			for _, s := range all {
+				if s.Status == state.StatusRunning {
+					states = append(states, s)
+				}
+			}
+		}
+		for _, ref := range args {
+			s, err := state.Find(ref)
+			if err != nil {
+				return err
+			}
+			states = append(states, s)
+		}
+
+		stats := []containerStats{}
+		for _, s := range states {
+			st, err := container.Stats(s)
+			if err != nil {
+				return err
+			}
+			stats = append(stats, containerStats{ID: s.ID, Name: s.Name, Stats: st})
+		}
+
+		switch statsFormat {
+		case "json":
+			return printJSON(stats)
+		case "table":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "CONTAINER ID\tNAME\tCPU TIME\tMEM USAGE / LIMIT\tPIDS / LIMIT")
+			for _, s := range stats {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s / %s\t%d / %s\n",
+					state.ShortID(s.ID),
+					s.Name,
+					s.CPUUsage.Round(time.Millisecond),
+					humanBytes(s.MemoryUsage),
+					limitString(s.MemoryLimit, humanBytes),
+					s.Pids,
+					limitString(s.PidsLimit, func(n uint64) string { return strconv.FormatUint(n, 10) }),
+				)
+			}
+			return w.Flush()
+		default:
+			return fmt.Errorf("unknown format %q, expected table or json", statsFormat)
+		}
+	},
+}
+
+func limitString(limit uint64, format func(uint64) string) string {
+	if limit == 0 {
+		return "max"
+	}
+	return format(limit)
+}
+
+func humanBytes(n uint64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.2fGiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.2fMiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.2fKiB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
+
+func init() {
+	statsCmd.Flags().StringVar(&statsFormat, "format", "table", "Output format: table or json")
+
+	rootCmd.AddCommand(statsCmd)
+}
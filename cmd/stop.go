@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/truongnhatanh7/xocker/internal/container"
+	"github.com/truongnhatanh7/xocker/internal/state"
+)
+
+var stopTimeout int
+
+var stopCmd = &cobra.Command{
+	Use:   "stop CONTAINER [CONTAINER...]",
+	Short: "Stop one or more running containers",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		for _, ref := range args {
+			st, err := state.Find(ref)
+			if err != nil {
+				return err
+			}
+			if err := container.Stop(st, time.Duration(stopTimeout)*time.Second); err != nil {
+				return fmt.Errorf("failed to stop %s: %w", ref, err)
+			}
+			fmt.Println(ref)
+		}
+		return nil
+	},
+}
+
+func init() {
+	stopCmd.Flags().IntVarP(&stopTimeout, "time", "t", 10, "Seconds to wait for the container to stop before killing it")
+
+	rootCmd.AddCommand(stopCmd)
+}
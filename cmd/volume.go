@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/truongnhatanh7/xocker/internal/volume"
+)
+
+var (
+	volumeLsFormat string
+	volumeRmForce  bool
+)
+
+var volumeCmd = &cobra.Command{
+	Use:   "volume",
+	Short: "Manage volumes",
+}
+
+var volumeCreateCmd = &cobra.Command{
+	Use:   "create [VOLUME]",
+	Short: "Create a volume",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := ""
+		if len(args) == 1 {
+			name = args[0]
+		}
+		v, err := volume.Create(name)
+		if err != nil {
+			return err
+		}
+		fmt.Println(v.Name)
+		return nil
+	},
+}
+
+var volumeLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List volumes",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		volumes, err := volume.List()
+		if err != nil {
+			return err
+		}
+
+		switch volumeLsFormat {
+		case "json":
+			if volumes == nil {
+				volumes = []*volume.Volume{}
+			}
+			return printJSON(volumes)
+		case "table":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "DRIVER\tVOLUME NAME\tMOUNTPOINT")
+			for _, v := range volumes {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", v.Driver, v.Name, v.Mountpoint)
+			}
+			return w.Flush()
+		default:
+			return fmt.Errorf("unknown format %q, expected table or json", volumeLsFormat)
+		}
+	},
+}
+
+var volumeRmCmd = &cobra.Command{
+	Use:   "rm VOLUME [VOLUME...]",
+	Short: "Remove one or more volumes",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		for _, name := range args {
+			if err := volume.Remove(name, volumeRmForce); err != nil {
+				return err
+			}
+			fmt.Println(name)
+		}
+		return nil
+	},
+}
+
+var volumeInspectCmd = &cobra.Command{
+	Use:   "inspect VOLUME [VOLUME...]",
+	Short: "Display detailed information on one or more volumes",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var volumes []*volume.Volume
+		for _, name := range args {
+			v, err := volume.Get(name)
+			if err != nil {
+				return err
+			}
+			volumes = append(volumes, v)
+		}
+		return printJSON(volumes)
+	},
+}
+
+func init() {
+	volumeLsCmd.Flags().StringVar(&volumeLsFormat, "format", "table", "Output format: table or json")
+	volumeRmCmd.Flags().BoolVarP(&volumeRmForce, "force", "f", false, "Remove the volume even if containers reference it")
+
+	volumeCmd.AddCommand(volumeCreateCmd)
+	volumeCmd.AddCommand(volumeLsCmd)
+	volumeCmd.AddCommand(volumeRmCmd)
+	volumeCmd.AddCommand(volumeInspectCmd)
+	rootCmd.AddCommand(volumeCmd)
+}
@@ -0,0 +1,265 @@
+package cgroupv2
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// parent of the container cgroups, it holds no process itself so its
+	// children can use the controllers
+	parentGroup = "xocker"
+	// leaf the processes of a namespace root are moved to, a non root cgroup
+	// holding processes can't delegate controllers to its children
+	initGroup = "init"
+	cpuPeriod = uint64(100000)
+)
+
+// controllers the limits are written to, io and cpuset are only enabled when used
+var controllers = []string{"cpu", "memory", "pids"}
+
+type cgroupFile struct {
+	name  string
+	value string
+}
+
+// Cgroupfs writes the cgroup files under Root directly, for hosts and CI
+// containers without systemd
+type Cgroupfs struct {
+	containerID string
+	path        string
+}
+
+func NewCgroupfs(containerID string) *Cgroupfs {
+	return &Cgroupfs{containerID: containerID}
+}
+
+func (c *Cgroupfs) Name() string {
+	return DriverCgroupfs
+}
+
+func (c *Cgroupfs) Limit(s *CgroupV2SetSpecs) error {
+	if s == nil {
+		panic("spec cannot be nil")
+	}
+
+	var fs unix.Statfs_t
+	if err := unix.Statfs(Root, &fs); err != nil {
+		return fmt.Errorf("failed to stat %s: %w", Root, err)
+	}
+	if fs.Type != unix.CGROUP2_SUPER_MAGIC {
+		return fmt.Errorf("%s is not a cgroup v2 mount, the cgroupfs driver needs the unified hierarchy", Root)
+	}
+
+	parent := filepath.Join(Root, parentGroup)
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return fmt.Errorf("failed to create cgroup %s: %w", parent, err)
+	}
+	needed := append([]string{}, controllers...)
+	if !s.IOSpec.IsZero() {
+		needed = append(needed, "io")
+	}
+	if s.CPUSpec != nil && (s.CPUSpec.Cpus != "" || s.CPUSpec.Mems != "") {
+		needed = append(needed, "cpuset")
+	}
+	if err := enableRootControllers(needed); err != nil {
+		return err
+	}
+	if err := enableControllers(parent, needed); err != nil {
+		return err
+	}
+
+	path := filepath.Join(parent, c.containerID)
+	if err := os.Mkdir(path, 0o755); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("failed to create cgroup %s: %w", path, err)
+	}
+	c.path = path
+
+	cpuMax := "max"
+	if s.CPUSpec != nil && s.CPUSpec.Quota > 0 {
+		// the quota is CPU time per second, cpu.max wants it per period
+		cpuMax = fmt.Sprintf("%d %d", s.CPUSpec.Quota*cpuPeriod/1000000, cpuPeriod)
+	}
+	memMax := "max"
+	var memFiles []cgroupFile
+	if mem := s.MemSpec; mem != nil {
+		if err := mem.Validate(); err != nil {
+			return err
+		}
+		if mem.Limit > 0 {
+			limit := strconv.FormatUint(mem.limitBytes(), 10)
+			if mem.OOMKillDisable {
+				memFiles = append(memFiles, cgroupFile{"memory.high", limit})
+			} else {
+				memMax = limit
+			}
+		}
+		if mem.Reservation > 0 {
+			memFiles = append(memFiles, cgroupFile{"memory.low", strconv.FormatUint(mem.Reservation, 10)})
+		}
+		switch {
+		case mem.Swap == -1:
+			memFiles = append(memFiles, cgroupFile{"memory.swap.max", "max"})
+		case mem.Swap > 0:
+			// memory.swap.max only counts the swap
+			memFiles = append(memFiles, cgroupFile{"memory.swap.max", strconv.FormatUint(uint64(mem.Swap)-mem.limitBytes(), 10)})
+		}
+	}
+	pidsMax := "max"
+	if s.PidsSpec != nil && s.PidsSpec.Limit > 0 {
+		pidsMax = strconv.FormatInt(s.PidsSpec.Limit, 10)
+	}
+
+	files := []cgroupFile{
+		{"cpu.max", cpuMax},
+		{"memory.max", memMax},
+		{"pids.max", pidsMax},
+	}
+	files = append(files, memFiles...)
+	if cpu := s.CPUSpec; cpu != nil {
+		if cpu.Shares > 0 {
+			files = append(files, cgroupFile{"cpu.weight", strconv.FormatUint(cpuWeight(cpu.Shares), 10)})
+		}
+		if cpu.Cpus != "" {
+			files = append(files, cgroupFile{"cpuset.cpus", cpu.Cpus})
+		}
+		if cpu.Mems != "" {
+			files = append(files, cgroupFile{"cpuset.mems", cpu.Mems})
+		}
+	}
+	if !s.IOSpec.IsZero() {
+		if s.IOSpec.Weight > 0 {
+			files = append(files, cgroupFile{"io.weight", fmt.Sprintf("default %d", ioWeight(s.IOSpec.Weight))})
+		}
+		// io.max takes a single device and key per write
+		for _, line := range s.IOSpec.ioMaxLines() {
+			files = append(files, cgroupFile{"io.max", line})
+		}
+	}
+	// last, the limits are in place once the process is in
+	files = append(files, cgroupFile{"cgroup.procs", strconv.Itoa(s.ApplyToPid)})
+
+	for _, f := range files {
+		if err := writeFile(path, f.name, f.value); err != nil {
+			c.Destroy()
+			return err
+		}
+	}
+
+	logger.Log.Debug("cgroup configured",
+		zap.String("path", path),
+		zap.Int("pid", s.ApplyToPid),
+		zap.String("cpu.max", cpuMax),
+		zap.String("memory.max", memMax),
+		zap.String("pids.max", pidsMax))
+	return nil
+}
+
+// enableRootControllers delegates the controllers to the children of Root.
+// Inside a container with a cgroup namespace Root is a regular cgroup that
+// still holds processes, they are moved to a leaf first like runc and
+// containerd do.
+func enableRootControllers(controllers []string) error {
+	err := enableControllers(Root, controllers)
+	if !errors.Is(err, unix.EBUSY) {
+		return err
+	}
+
+	leaf := filepath.Join(Root, initGroup)
+	logger.Log.Debug("moving the processes of the cgroup root to a leaf", zap.String("root", Root), zap.String("leaf", leaf))
+	if err := moveProcesses(Root, leaf); err != nil {
+		return fmt.Errorf("%s holds processes so its controllers can't be delegated, and moving them to %s failed: %w", Root, leaf, err)
+	}
+	if err := enableControllers(Root, controllers); err != nil {
+		return fmt.Errorf("failed to delegate controllers after moving the processes of %s to %s: %w", Root, leaf, err)
+	}
+	return nil
+}
+
+// moveProcesses moves every process of the cgroup from into the cgroup to
+func moveProcesses(from, to string) error {
+	if err := os.MkdirAll(to, 0o755); err != nil {
+		return fmt.Errorf("failed to create cgroup %s: %w", to, err)
+	}
+	data, err := os.ReadFile(filepath.Join(from, "cgroup.procs"))
+	if err != nil {
+		return err
+	}
+	for _, pid := range strings.Fields(string(data)) {
+		// it may have exited since
+		if err := writeFile(to, "cgroup.procs", pid); err != nil && !errors.Is(err, unix.ESRCH) {
+			return err
+		}
+	}
+	return nil
+}
+
+// enableControllers delegates the controllers to the children of dir
+func enableControllers(dir string, controllers []string) error {
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return fmt.Errorf("failed to read controllers of %s: %w", dir, err)
+	}
+	available := strings.Fields(string(data))
+
+	var enable []string
+	for _, name := range controllers {
+		found := false
+		for _, a := range available {
+			if a == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("the %s controller is not available in %s", name, dir)
+		}
+		enable = append(enable, "+"+name)
+	}
+	return writeFile(dir, "cgroup.subtree_control", strings.Join(enable, " "))
+}
+
+func writeFile(dir, name, value string) error {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s to %s: %w", value, filepath.Join(dir, name), err)
+	}
+	return nil
+}
+
+func (c *Cgroupfs) Path() string {
+	return c.path
+}
+
+func (c *Cgroupfs) Unit() string {
+	return ""
+}
+
+// Destroy kills what is left in the cgroup and removes it
+func (c *Cgroupfs) Destroy() {
+	if c.path == "" {
+		return
+	}
+	// cgroup.kill exists since linux 5.14
+	if err := writeFile(c.path, "cgroup.kill", "1"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Log.Debug("failed to kill cgroup", zap.String("path", c.path), zap.Error(err))
+	}
+
+	var err error
+	for i := 0; i < 50; i++ {
+		// busy until the killed processes are reaped
+		if err = RemovePath(c.path); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	logger.Log.Warn("failed to remove cgroup", zap.String("path", c.path), zap.Error(err))
+}
@@ -3,12 +3,6 @@ package cgroupv2
 import (
 	"fmt"
 	"os"
-	"path/filepath"
-
-	"github.com/godbus/dbus/v5"
-	"github.com/truongnhatanh7/xocker/internal/common"
-	"github.com/truongnhatanh7/xocker/internal/logger"
-	"go.uber.org/zap"
 )
 
 var (
@@ -16,89 +10,118 @@ var (
 	ONE_CPU_QUOTA  = uint64(1000000)
 )
 
-type CgroupV2 struct {
-	path     string
-	dbusConn *dbus.Conn
-}
+// Root is the mount point of the unified hierarchy
+var Root = "/sys/fs/cgroup"
 
-func NewCgroupV2(containerId string) *CgroupV2 {
-	path := filepath.Join("/sys/fs/cgroup/xocker", containerId)
-	common.Must(os.MkdirAll(path, 0755))
-	return &CgroupV2{
-		path: path,
-	}
+const (
+	DriverSystemd  = "systemd"
+	DriverCgroupfs = "cgroupfs"
+)
+
+// CgroupDriver puts a container process in a cgroup and applies its limits
+type CgroupDriver interface {
+	// Name is DriverSystemd or DriverCgroupfs
+	Name() string
+	// Limit moves the process of the specs into the cgroup and applies the limits
+	Limit(s *CgroupV2SetSpecs) error
+	// Path is the cgroup directory, empty before Limit is called
+	Path() string
+	// Unit is the systemd transient unit name, empty for cgroupfs
+	Unit() string
+	// Destroy releases the cgroup once the container has exited
+	Destroy()
 }
 
 type CgroupV2SetSpecs struct {
 	ApplyToPid int
 	CPUSpec    *CPUSpec
 	MemSpec    *MemSpec
+	PidsSpec   *PidsSpec
+	IOSpec     *IOSpec
 }
 
 type CPUSpec struct {
+	// CPU time per second in microseconds (CPUQuotaPerSecUSec)
 	Quota uint64
+	// relative weight like docker cpu shares (2-262144), 0 keeps the default
+	Shares uint64
+	// cpuset lists like 0-3,6, empty means all
+	Cpus string
+	Mems string
 }
 
 type MemSpec struct {
+	// in MiB
 	Limit uint64
+	// memory plus swap in bytes like docker --memory-swap, -1 is unlimited
+	// and 0 keeps the default
+	Swap int64
+	// memory protected from reclaim in bytes (memory.low), 0 is none
+	Reservation uint64
+	// throttle and reclaim at Limit (memory.high) instead of OOM killing,
+	// cgroup v2 can't turn the OOM killer off
+	OOMKillDisable bool
 }
 
-func (c *CgroupV2) Limit(s *CgroupV2SetSpecs) {
-	if s == nil {
-		panic("spec cannot be nil")
-	}
-
-	logger.Log.Debug("limit pid", zap.Int("pid", s.ApplyToPid))
-	logger.Log.Debug("cpu", zap.Uint64("quota", s.CPUSpec.Quota))
-	logger.Log.Debug("mem", zap.Uint64("lim", s.MemSpec.Limit))
+func (m *MemSpec) limitBytes() uint64 {
+	return m.Limit * 1024 * 1024
+}
 
-	conn, err := dbus.SystemBus()
-	common.Must(err)
-	c.dbusConn = conn
+// Validate checks the swap and reservation against the limit like docker
+func (m *MemSpec) Validate() error {
+	if m.Swap < -1 {
+		return fmt.Errorf("invalid memory swap %d, expected a size or -1", m.Swap)
+	}
+	if m.Swap > 0 {
+		if m.Limit == 0 {
+			return fmt.Errorf("memory swap requires a memory limit")
+		}
+		if uint64(m.Swap) < m.limitBytes() {
+			return fmt.Errorf("memory swap must be larger than the memory limit, it includes it")
+		}
+	}
+	if m.Limit > 0 && m.Reservation > m.limitBytes() {
+		return fmt.Errorf("memory reservation must be lower than the memory limit")
+	}
+	if m.OOMKillDisable && m.Limit == 0 {
+		return fmt.Errorf("disabling the OOM killer requires a memory limit")
+	}
+	return nil
+}
 
-	unitName := fmt.Sprintf("xocker-%d.scope", s.ApplyToPid)
-	systemd := conn.Object(
-		"org.freedesktop.systemd1",
-		"/org/freedesktop/systemd1",
-	)
+type PidsSpec struct {
+	// max number of tasks in the cgroup, unlimited if <= 0
+	Limit int64
+}
 
-	props := []struct {
-		Name  string
-		Value dbus.Variant
-	}{
-		{
-			Name:  "PIDs",
-			Value: dbus.MakeVariant([]uint32{uint32(s.ApplyToPid)}),
-		},
-		{
-			Name:  "MemoryMax",
-			Value: dbus.MakeVariant(uint64(s.MemSpec.Limit * 1024 * 1024)),
-		},
-		{
-			Name:  "CPUQuotaPerSecUSec",
-			Value: dbus.MakeVariant(s.CPUSpec.Quota),
-		},
+// NewDriver returns the driver called name, an empty name picks systemd when
+// the host runs it and cgroupfs otherwise
+func NewDriver(name string, containerID string) (CgroupDriver, error) {
+	if name == "" {
+		name = DefaultDriver()
 	}
+	switch name {
+	case DriverSystemd:
+		return NewSystemd(containerID), nil
+	case DriverCgroupfs:
+		return NewCgroupfs(containerID), nil
+	default:
+		return nil, fmt.Errorf("unknown cgroup driver %q, expected %s or %s", name, DriverSystemd, DriverCgroupfs)
+	}
+}
 
-	aux := []struct {
-		Name  string
-		Value []struct {
-			Name  string
-			Value dbus.Variant
-		}
-	}{}
-
-	call := systemd.Call(
-		"org.freedesktop.systemd1.Manager.StartTransientUnit",
-		0,
-		unitName,
-		"replace",
-		props,
-		aux,
-	)
-	common.Must(call.Err)
+// DefaultDriver is systemd on hosts booted with it, like sd_booted(3)
+func DefaultDriver() string {
+	if fi, err := os.Stat("/run/systemd/system"); err == nil && fi.IsDir() {
+		return DriverSystemd
+	}
+	return DriverCgroupfs
 }
 
-func (c *CgroupV2) Destroy() {
-	c.dbusConn.Close()
+// RemovePath removes a cgroup directory, it is a no-op if it is already gone
+func RemovePath(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
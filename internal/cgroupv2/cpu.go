@@ -0,0 +1,127 @@
+package cgroupv2
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	onlineCPUs  = "/sys/devices/system/cpu/online"
+	onlineNodes = "/sys/devices/system/node/online"
+
+	minCPUShares = 2
+	maxCPUShares = 262144
+)
+
+// ValidateCPUShares checks a --cpu-shares value, 0 means unset
+func ValidateCPUShares(shares uint64) error {
+	if shares != 0 && (shares < minCPUShares || shares > maxCPUShares) {
+		return fmt.Errorf("invalid cpu shares %d, expected %d-%d", shares, minCPUShares, maxCPUShares)
+	}
+	return nil
+}
+
+// cpuWeight maps cpu shares (2-262144) to cpu.weight (1-10000) like runc
+func cpuWeight(shares uint64) uint64 {
+	return 1 + (shares-minCPUShares)*9999/(maxCPUShares-minCPUShares)
+}
+
+// CPUsQuota converts a docker style --cpus value into the CPU time per second
+// in microseconds, it can't exceed the online CPUs of the host
+func CPUsQuota(cpus float64) (uint64, error) {
+	online, err := ParseCPUSet(readOnline(onlineCPUs))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read online CPUs: %w", err)
+	}
+	if cpus < 0.01 || cpus > float64(len(online)) {
+		return 0, fmt.Errorf("invalid cpus %g, the range of CPUs is from 0.01 to %d", cpus, len(online))
+	}
+	return uint64(cpus * float64(ONE_CPU_QUOTA)), nil
+}
+
+// ValidateCpusetCpus checks a --cpuset-cpus list against the online CPUs
+func ValidateCpusetCpus(list string) error {
+	return validateCpuset(list, onlineCPUs, "CPUs")
+}
+
+// ValidateCpusetMems checks a --cpuset-mems list against the online memory nodes
+func ValidateCpusetMems(list string) error {
+	return validateCpuset(list, onlineNodes, "memory nodes")
+}
+
+func validateCpuset(list, onlineFile, what string) error {
+	ids, err := ParseCPUSet(list)
+	if err != nil {
+		return err
+	}
+	online, err := ParseCPUSet(readOnline(onlineFile))
+	if err != nil {
+		return fmt.Errorf("failed to read online %s: %w", what, err)
+	}
+
+	available := map[int]bool{}
+	for _, id := range online {
+		available[id] = true
+	}
+	for _, id := range ids {
+		if !available[id] {
+			return fmt.Errorf("%s %d requested in %q are not available, online %s are %s", what, id, list, what, readOnline(onlineFile))
+		}
+	}
+	return nil
+}
+
+// readOnline reads a sysfs list of online ids, hosts without NUMA have no
+// node directory and a single node 0
+func readOnline(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "0"
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// ParseCPUSet parses the cpuset list format, like 0-3,6 or 1
+func ParseCPUSet(list string) ([]int, error) {
+	seen := map[int]bool{}
+	for _, part := range strings.Split(list, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		start, err := strconv.Atoi(first)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid cpuset %q", list)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(last); err != nil || end < start {
+				return nil, fmt.Errorf("invalid cpuset %q", list)
+			}
+		}
+		for id := start; id <= end; id++ {
+			seen[id] = true
+		}
+	}
+
+	ids := make([]int, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// cpuSetMask encodes a cpuset list as the little endian bitmask of the
+// systemd AllowedCPUs and AllowedMemoryNodes properties
+func cpuSetMask(list string) ([]byte, error) {
+	ids, err := ParseCPUSet(list)
+	if err != nil {
+		return nil, err
+	}
+	mask := make([]byte, ids[len(ids)-1]/8+1)
+	for _, id := range ids {
+		mask[id/8] |= 1 << (id % 8)
+	}
+	return mask, nil
+}
@@ -0,0 +1,67 @@
+package cgroupv2
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCPUWeight(t *testing.T) {
+	tests := []struct {
+		shares uint64
+		want   uint64
+	}{
+		{minCPUShares, 1},
+		{1024, 39}, // docker's default shares, same weight as runc gives it
+		{maxCPUShares, 10000},
+	}
+	for _, tt := range tests {
+		if got := cpuWeight(tt.shares); got != tt.want {
+			t.Errorf("cpuWeight(%d) = %d, want %d", tt.shares, got, tt.want)
+		}
+	}
+}
+
+func TestParseCPUSet(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []int
+		wantErr bool
+	}{
+		{"0", []int{0}, false},
+		{"0-3", []int{0, 1, 2, 3}, false},
+		{"6,0-2, 1", []int{0, 1, 2, 6}, false},
+		{"", nil, true},
+		{"3-1", nil, true},
+		{"-1", nil, true},
+		{"a", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.list, func(t *testing.T) {
+			got, err := ParseCPUSet(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCPUSet() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ParseCPUSet() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCPUSetMask(t *testing.T) {
+	tests := []struct {
+		list string
+		want []byte
+	}{
+		{"0", []byte{0x01}},
+		{"0-3", []byte{0x0f}},
+		{"1,9", []byte{0x02, 0x02}},
+	}
+	for _, tt := range tests {
+		got, err := cpuSetMask(tt.list)
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("cpuSetMask(%q) = %x %v, want %x", tt.list, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package cgroupv2
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/truongnhatanh7/xocker/internal/common"
+	"golang.org/x/sys/unix"
+)
+
+type IOSpec struct {
+	// blkio weight 10-1000 like docker, 0 keeps the default
+	Weight    uint16
+	ReadBps   []DeviceLimit
+	WriteBps  []DeviceLimit
+	ReadIOPS  []DeviceLimit
+	WriteIOPS []DeviceLimit
+}
+
+// DeviceLimit throttles one block device, Major and Minor are resolved from
+// Path when parsing
+type DeviceLimit struct {
+	Path  string
+	Major uint32
+	Minor uint32
+	Rate  uint64
+}
+
+func (s *IOSpec) IsZero() bool {
+	return s == nil || s.Weight == 0 &&
+		len(s.ReadBps) == 0 && len(s.WriteBps) == 0 &&
+		len(s.ReadIOPS) == 0 && len(s.WriteIOPS) == 0
+}
+
+// ValidateBlkioWeight checks a --blkio-weight value, 0 means unset
+func ValidateBlkioWeight(weight uint16) error {
+	if weight != 0 && (weight < 10 || weight > 1000) {
+		return fmt.Errorf("invalid blkio weight %d, expected 10-1000", weight)
+	}
+	return nil
+}
+
+// ioWeight maps a blkio weight (10-1000) to io.weight (1-10000) like runc
+func ioWeight(blkioWeight uint16) uint64 {
+	return 1 + (uint64(blkioWeight)-10)*9999/990
+}
+
+// ParseDeviceLimit parses device:rate, rate is a size like 1mb for the bps
+// flags and a plain number for the iops ones
+func ParseDeviceLimit(spec string, bytes bool) (DeviceLimit, error) {
+	path, value, ok := strings.Cut(spec, ":")
+	if !ok || path == "" || value == "" {
+		return DeviceLimit{}, fmt.Errorf("invalid device limit %q, expected <device>:<rate>", spec)
+	}
+
+	var rate uint64
+	var err error
+	if bytes {
+		rate, err = common.ParseBytes(value)
+	} else {
+		rate, err = strconv.ParseUint(value, 10, 64)
+	}
+	if err != nil {
+		return DeviceLimit{}, fmt.Errorf("invalid rate in device limit %q: %w", spec, err)
+	}
+	if rate == 0 {
+		return DeviceLimit{}, fmt.Errorf("invalid rate in device limit %q, must be positive", spec)
+	}
+
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return DeviceLimit{}, fmt.Errorf("failed to stat device %s: %w", path, err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFBLK {
+		return DeviceLimit{}, fmt.Errorf("%s is not a block device", path)
+	}
+	return DeviceLimit{
+		Path:  path,
+		Major: unix.Major(uint64(st.Rdev)),
+		Minor: unix.Minor(uint64(st.Rdev)),
+		Rate:  rate,
+	}, nil
+}
+
+// ioMaxLines returns the io.max lines of the spec, one per device and key
+func (s *IOSpec) ioMaxLines() []string {
+	var lines []string
+	keys := []struct {
+		key    string
+		limits []DeviceLimit
+	}{
+		{"rbps", s.ReadBps},
+		{"wbps", s.WriteBps},
+		{"riops", s.ReadIOPS},
+		{"wiops", s.WriteIOPS},
+	}
+	for _, k := range keys {
+		for _, d := range k.limits {
+			lines = append(lines, fmt.Sprintf("%d:%d %s=%d", d.Major, d.Minor, k.key, d.Rate))
+		}
+	}
+	return lines
+}
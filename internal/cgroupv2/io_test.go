@@ -0,0 +1,46 @@
+package cgroupv2
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIOWeight(t *testing.T) {
+	tests := []struct {
+		weight uint16
+		want   uint64
+	}{
+		{10, 1},
+		{500, 4950}, // docker's default blkio weight, same as runc
+		{1000, 10000},
+	}
+	for _, tt := range tests {
+		if got := ioWeight(tt.weight); got != tt.want {
+			t.Errorf("ioWeight(%d) = %d, want %d", tt.weight, got, tt.want)
+		}
+	}
+}
+
+func TestValidateBlkioWeight(t *testing.T) {
+	for _, w := range []uint16{0, 10, 1000} {
+		if err := ValidateBlkioWeight(w); err != nil {
+			t.Errorf("ValidateBlkioWeight(%d) = %v", w, err)
+		}
+	}
+	for _, w := range []uint16{9, 1001} {
+		if err := ValidateBlkioWeight(w); err == nil {
+			t.Errorf("ValidateBlkioWeight(%d) accepted", w)
+		}
+	}
+}
+
+func TestIOMaxLines(t *testing.T) {
+	s := &IOSpec{
+		ReadBps:   []DeviceLimit{{Major: 8, Minor: 0, Rate: 1 << 20}},
+		WriteIOPS: []DeviceLimit{{Major: 8, Minor: 0, Rate: 100}, {Major: 253, Minor: 1, Rate: 50}},
+	}
+	want := []string{"8:0 rbps=1048576", "8:0 wiops=100", "253:1 wiops=50"}
+	if got := s.ioMaxLines(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ioMaxLines() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,101 @@
+package cgroupv2
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// systemd creates the scope asynchronously, its cgroup may show up a bit
+// after Limit returns
+const eventsWaitTimeout = time.Second
+
+// OOMWatcher follows the oom_kill counter of memory.events, the kernel
+// notifies a modification whenever one of its counters changes
+type OOMWatcher struct {
+	dir   string
+	file  *os.File
+	kills atomic.Uint64
+}
+
+// WatchOOM starts watching the memory.events file of the cgroup at path
+func WatchOOM(path string) (*OOMWatcher, error) {
+	events := filepath.Join(path, "memory.events")
+	for deadline := time.Now().Add(eventsWaitTimeout); ; {
+		_, err := os.Stat(events)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) || time.Now().After(deadline) {
+			return nil, fmt.Errorf("failed to watch %s: %w", events, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// non blocking so the runtime poller can interrupt the read on Close
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init inotify: %w", err)
+	}
+	if _, err := unix.InotifyAddWatch(fd, events, unix.IN_MODIFY); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to watch %s: %w", events, err)
+	}
+
+	w := &OOMWatcher{dir: path, file: os.NewFile(uintptr(fd), "inotify")}
+	w.update()
+	go w.run()
+	return w, nil
+}
+
+func (w *OOMWatcher) run() {
+	buf := make([]byte, 4096)
+	for {
+		if _, err := w.file.Read(buf); err != nil {
+			// closed, or the cgroup is gone
+			return
+		}
+		w.update()
+	}
+}
+
+func (w *OOMWatcher) update() {
+	data, err := os.ReadFile(filepath.Join(w.dir, "memory.events"))
+	if err != nil {
+		// systemd removes the scope once it is empty, keep the last count
+		return
+	}
+	kills, err := parseKey(data, "oom_kill")
+	if err != nil {
+		return
+	}
+	// the counter only grows, a stale read must not lower it
+	for {
+		prev := w.kills.Load()
+		if kills <= prev {
+			return
+		}
+		if w.kills.CompareAndSwap(prev, kills) {
+			logger.Log.Warn("OOM killer killed a process in the container", zap.String("cgroup", w.dir), zap.Uint64("oomKills", kills))
+			return
+		}
+	}
+}
+
+// Killed reports whether the OOM killer killed a process of the cgroup, the
+// counter of a new cgroup starts at zero
+func (w *OOMWatcher) Killed() bool {
+	w.update()
+	return w.kills.Load() > 0
+}
+
+func (w *OOMWatcher) Close() {
+	w.file.Close()
+}
@@ -0,0 +1,51 @@
+package cgroupv2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"go.uber.org/zap"
+)
+
+func TestOOMWatcherKeepsCountAfterScopeRemoval(t *testing.T) {
+	logger.Log = zap.NewNop()
+	dir := t.TempDir()
+	events := filepath.Join(dir, "memory.events")
+	if err := os.WriteFile(events, []byte("oom 0\noom_kill 0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := WatchOOM(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if w.Killed() {
+		t.Fatal("reported an OOM kill before any")
+	}
+
+	if err := os.WriteFile(events, []byte("oom 1\noom_kill 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Killed() {
+		t.Fatal("missed the OOM kill")
+	}
+
+	// systemd removes the scope of an exited container
+	if err := os.Remove(events); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Killed() {
+		t.Fatal("lost the OOM kill once memory.events is gone")
+	}
+
+	// a stale lower count must not reset it either
+	if err := os.WriteFile(events, []byte("oom 0\noom_kill 0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Killed() {
+		t.Fatal("lost the OOM kill on a lower count")
+	}
+}
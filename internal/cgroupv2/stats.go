@@ -0,0 +1,97 @@
+package cgroupv2
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stats is a snapshot of the usage of a cgroup, limits of 0 mean unlimited
+type Stats struct {
+	CPUUsage    time.Duration `json:"cpuUsage"`
+	MemoryUsage uint64        `json:"memoryUsage"`
+	MemoryLimit uint64        `json:"memoryLimit"`
+	Pids        uint64        `json:"pids"`
+	PidsLimit   uint64        `json:"pidsLimit"`
+}
+
+// ReadStats reads the usage of the cgroup at path, the files of controllers
+// that aren't enabled read as zero
+func ReadStats(path string) (*Stats, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("failed to read cgroup stats: %w", err)
+	}
+
+	var s Stats
+	usec, err := readKey(path, "cpu.stat", "usage_usec")
+	if err != nil {
+		return nil, err
+	}
+	s.CPUUsage = time.Duration(usec) * time.Microsecond
+
+	values := []struct {
+		file string
+		dst  *uint64
+	}{
+		{"memory.current", &s.MemoryUsage},
+		{"memory.max", &s.MemoryLimit},
+		{"pids.current", &s.Pids},
+		{"pids.max", &s.PidsLimit},
+	}
+	for _, v := range values {
+		if *v.dst, err = readUint(path, v.file); err != nil {
+			return nil, err
+		}
+	}
+	return &s, nil
+}
+
+// readUint reads a single value file, "max" reads as 0
+func readUint(dir, name string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q in %s: %w", value, filepath.Join(dir, name), err)
+	}
+	return n, nil
+}
+
+// readKey reads a value of a flat keyed file like cpu.stat
+func readKey(dir, name, key string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return parseKey(data, key)
+}
+
+// parseKey finds the value of key in the content of a flat keyed file, a
+// missing key reads as 0
+func parseKey(data []byte, key string) (uint64, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == key {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	return 0, nil
+}
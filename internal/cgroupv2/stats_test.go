@@ -0,0 +1,57 @@
+package cgroupv2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadStats(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"cpu.stat":       "usage_usec 2500000\nuser_usec 2000000\nsystem_usec 500000\n",
+		"memory.current": "1048576\n",
+		"memory.max":     "max\n",
+		"pids.current":   "3\n",
+		"pids.max":       "100\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := ReadStats(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{CPUUsage: 2500 * time.Millisecond, MemoryUsage: 1 << 20, Pids: 3, PidsLimit: 100}
+	if *got != want {
+		t.Fatalf("ReadStats() = %+v, want %+v", *got, want)
+	}
+}
+
+func TestReadStatsMissingControllers(t *testing.T) {
+	got, err := ReadStats(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != (Stats{}) {
+		t.Fatalf("ReadStats() = %+v, want zero stats", *got)
+	}
+
+	if _, err := ReadStats(filepath.Join(t.TempDir(), "gone")); err == nil {
+		t.Fatal("ReadStats() of a removed cgroup succeeded")
+	}
+}
+
+func TestReadStatsInvalidValue(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "pids.current"), []byte("lots\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadStats(dir); err == nil {
+		t.Fatal("ReadStats() accepted an invalid value")
+	}
+}
@@ -0,0 +1,214 @@
+package cgroupv2
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Systemd delegates the cgroup to systemd, the container runs in a transient
+// scope unit started over D-Bus and removed by systemd once it is empty
+type Systemd struct {
+	containerID string
+	path        string
+	unit        string
+	dbusConn    *dbus.Conn
+}
+
+// property is a unit property in the (sv) D-Bus signature
+type property struct {
+	Name  string
+	Value dbus.Variant
+}
+
+func NewSystemd(containerID string) *Systemd {
+	return &Systemd{containerID: containerID}
+}
+
+func (c *Systemd) Name() string {
+	return DriverSystemd
+}
+
+func (c *Systemd) Limit(s *CgroupV2SetSpecs) error {
+	if s == nil {
+		panic("spec cannot be nil")
+	}
+
+	logger.Log.Debug("limit pid", zap.Int("pid", s.ApplyToPid))
+	logger.Log.Debug("cpu", zap.Uint64("quota", s.CPUSpec.Quota))
+	logger.Log.Debug("mem", zap.Uint64("lim", s.MemSpec.Limit))
+	if s.PidsSpec != nil {
+		logger.Log.Debug("pids", zap.Int64("lim", s.PidsSpec.Limit))
+	}
+
+	if err := s.MemSpec.Validate(); err != nil {
+		return err
+	}
+
+	props, err := properties(s)
+	if err != nil {
+		return err
+	}
+
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the systemd bus: %w", err)
+	}
+	c.dbusConn = conn
+
+	unitName := fmt.Sprintf("xocker-%d.scope", s.ApplyToPid)
+	c.unit = unitName
+	systemd := conn.Object(
+		"org.freedesktop.systemd1",
+		"/org/freedesktop/systemd1",
+	)
+
+	aux := []struct {
+		Name  string
+		Value []property
+	}{}
+
+	call := systemd.Call(
+		"org.freedesktop.systemd1.Manager.StartTransientUnit",
+		0,
+		unitName,
+		"replace",
+		props,
+		aux,
+	)
+	if call.Err != nil {
+		return fmt.Errorf("failed to start unit %s: %w", unitName, call.Err)
+	}
+	// scopes started on the system bus land in system.slice
+	c.path = filepath.Join(Root, "system.slice", unitName)
+	return nil
+}
+
+// properties are the unit properties applying the limits of s, a zero quota
+// or memory limit is unlimited like with the cgroupfs driver
+func properties(s *CgroupV2SetSpecs) ([]property, error) {
+	mem := s.MemSpec
+	memoryMax := unlimitedIfZero(mem.limitBytes())
+	if mem.OOMKillDisable {
+		// throttled at MemoryHigh instead
+		memoryMax = math.MaxUint64
+	}
+
+	props := []property{
+		{
+			Name:  "PIDs",
+			Value: dbus.MakeVariant([]uint32{uint32(s.ApplyToPid)}),
+		},
+		{
+			Name:  "MemoryMax",
+			Value: dbus.MakeVariant(memoryMax),
+		},
+		{
+			Name:  "CPUQuotaPerSecUSec",
+			Value: dbus.MakeVariant(unlimitedIfZero(s.CPUSpec.Quota)),
+		},
+	}
+
+	if mem.OOMKillDisable {
+		props = append(props, property{Name: "MemoryHigh", Value: dbus.MakeVariant(unlimitedIfZero(mem.limitBytes()))})
+	}
+	if mem.Reservation > 0 {
+		props = append(props, property{Name: "MemoryLow", Value: dbus.MakeVariant(mem.Reservation)})
+	}
+	switch {
+	case mem.Swap == -1:
+		props = append(props, property{Name: "MemorySwapMax", Value: dbus.MakeVariant(uint64(math.MaxUint64))})
+	case mem.Swap > 0:
+		props = append(props, property{Name: "MemorySwapMax", Value: dbus.MakeVariant(uint64(mem.Swap) - mem.limitBytes())})
+	}
+
+	if io := s.IOSpec; !io.IsZero() {
+		if io.Weight > 0 {
+			props = append(props, property{Name: "IOWeight", Value: dbus.MakeVariant(ioWeight(io.Weight))})
+		}
+		devices := []struct {
+			name   string
+			limits []DeviceLimit
+		}{
+			{"IOReadBandwidthMax", io.ReadBps},
+			{"IOWriteBandwidthMax", io.WriteBps},
+			{"IOReadIOPSMax", io.ReadIOPS},
+			{"IOWriteIOPSMax", io.WriteIOPS},
+		}
+		for _, d := range devices {
+			if len(d.limits) > 0 {
+				props = append(props, property{Name: d.name, Value: dbus.MakeVariant(deviceRates(d.limits))})
+			}
+		}
+	}
+
+	if cpu := s.CPUSpec; cpu != nil {
+		if cpu.Shares > 0 {
+			props = append(props, property{Name: "CPUWeight", Value: dbus.MakeVariant(cpuWeight(cpu.Shares))})
+		}
+		cpusets := []struct{ name, list string }{
+			{"AllowedCPUs", cpu.Cpus},
+			{"AllowedMemoryNodes", cpu.Mems},
+		}
+		for _, set := range cpusets {
+			if set.list == "" {
+				continue
+			}
+			mask, err := cpuSetMask(set.list)
+			if err != nil {
+				return nil, err
+			}
+			props = append(props, property{Name: set.name, Value: dbus.MakeVariant(mask)})
+		}
+	}
+
+	if s.PidsSpec != nil && s.PidsSpec.Limit > 0 {
+		props = append(props, property{
+			Name:  "TasksMax",
+			Value: dbus.MakeVariant(uint64(s.PidsSpec.Limit)),
+		})
+	}
+
+	return props, nil
+}
+
+// unlimitedIfZero maps 0 to infinity, which systemd spells as the max uint64
+func unlimitedIfZero(v uint64) uint64 {
+	if v == 0 {
+		return math.MaxUint64
+	}
+	return v
+}
+
+// deviceRate is an entry of the IO*Max properties, a(st) on D-Bus
+type deviceRate struct {
+	Path string
+	Rate uint64
+}
+
+func deviceRates(limits []DeviceLimit) []deviceRate {
+	rates := make([]deviceRate, 0, len(limits))
+	for _, l := range limits {
+		rates = append(rates, deviceRate{Path: l.Path, Rate: l.Rate})
+	}
+	return rates
+}
+
+func (c *Systemd) Path() string {
+	return c.path
+}
+
+// Unit returns the systemd transient unit name, empty before Limit is called
+func (c *Systemd) Unit() string {
+	return c.unit
+}
+
+func (c *Systemd) Destroy() {
+	if c.dbusConn != nil {
+		c.dbusConn.Close()
+	}
+}
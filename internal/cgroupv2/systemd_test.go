@@ -0,0 +1,42 @@
+package cgroupv2
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPropertiesZeroIsUnlimited(t *testing.T) {
+	tests := []struct {
+		name       string
+		cpu        CPUSpec
+		mem        MemSpec
+		wantQuota  uint64
+		wantMemMax uint64
+		wantHigh   uint64 // 0 when MemoryHigh is not set
+	}{
+		{"limits", CPUSpec{Quota: HALF_CPU_QUOTA}, MemSpec{Limit: 128}, HALF_CPU_QUOTA, 128 << 20, 0},
+		{"no limits", CPUSpec{}, MemSpec{}, math.MaxUint64, math.MaxUint64, 0},
+		{"oom kill disabled", CPUSpec{}, MemSpec{Limit: 64, OOMKillDisable: true}, math.MaxUint64, math.MaxUint64, 64 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			props, err := properties(&CgroupV2SetSpecs{ApplyToPid: 1, CPUSpec: &tt.cpu, MemSpec: &tt.mem})
+			if err != nil {
+				t.Fatal(err)
+			}
+			values := map[string]any{}
+			for _, p := range props {
+				values[p.Name] = p.Value.Value()
+			}
+			if got := values["CPUQuotaPerSecUSec"]; got != tt.wantQuota {
+				t.Errorf("CPUQuotaPerSecUSec = %v, want %v", got, tt.wantQuota)
+			}
+			if got := values["MemoryMax"]; got != tt.wantMemMax {
+				t.Errorf("MemoryMax = %v, want %v", got, tt.wantMemMax)
+			}
+			if got, ok := values["MemoryHigh"]; ok != (tt.wantHigh != 0) || (ok && got != tt.wantHigh) {
+				t.Errorf("MemoryHigh = %v, want %v", got, tt.wantHigh)
+			}
+		})
+	}
+}
@@ -1,12 +1,7 @@
 package common
 
-import (
-	"fmt"
-)
-
 func Must(err error) {
 	if err != nil {
-		fmt.Errorf("\nerr %w", err)
 		panic(err)
 	}
 }
@@ -0,0 +1,83 @@
+package common
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var byteUnits = map[string]uint64{
+	"":   1,
+	"b":  1,
+	"k":  1 << 10,
+	"kb": 1 << 10,
+	"m":  1 << 20,
+	"mb": 1 << 20,
+	"g":  1 << 30,
+	"gb": 1 << 30,
+}
+
+// ParseBytes parses human sizes like "512", "10k", "64mb" or "1g" (binary units)
+func ParseBytes(s string) (uint64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	i := len(s)
+	for i > 0 && (s[i-1] < '0' || s[i-1] > '9') {
+		i--
+	}
+
+	num, unit := s[:i], s[i:]
+	mult, ok := byteUnits[unit]
+	if !ok || num == "" {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	n, err := strconv.ParseUint(num, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", s, err)
+	}
+	if n > math.MaxUint64/mult {
+		return 0, fmt.Errorf("invalid size %q, too large", s)
+	}
+	return n * mult, nil
+}
+
+// tc units, bit rates are decimal and bps means bytes per second
+var rateUnits = map[string]uint64{
+	"":     1,
+	"bit":  1,
+	"kbit": 1000,
+	"mbit": 1000 * 1000,
+	"gbit": 1000 * 1000 * 1000,
+	"bps":  8,
+	"kbps": 8 * 1000,
+	"mbps": 8 * 1000 * 1000,
+	"gbps": 8 * 1000 * 1000 * 1000,
+}
+
+// ParseRate parses tc style rates like "512kbit", "10mbit" or "1mbps" into bits per second
+func ParseRate(s string) (uint64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+
+	num, unit := s[:i], s[i:]
+	mult, ok := rateUnits[unit]
+	if !ok || num == "" {
+		return 0, fmt.Errorf("invalid rate %q, expected e.g. 512kbit, 10mbit or 1mbps", s)
+	}
+
+	n, err := strconv.ParseUint(num, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q: %w", s, err)
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("invalid rate %q, must be positive", s)
+	}
+	if n > math.MaxUint64/mult {
+		return 0, fmt.Errorf("invalid rate %q, too large", s)
+	}
+	return n * mult, nil
+}
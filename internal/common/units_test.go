@@ -0,0 +1,79 @@
+package common
+
+import "testing"
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint64
+		wantErr bool
+	}{
+		{in: "512", want: 512},
+		{in: "512b", want: 512},
+		{in: "10k", want: 10 << 10},
+		{in: "64MB", want: 64 << 20},
+		{in: " 1g ", want: 1 << 30},
+		{in: "0", want: 0},
+		{in: "17179869183g", want: 17179869183 << 30},
+		{in: "18446744073709551615", want: 18446744073709551615},
+		{in: "17179869184g", wantErr: true},
+		{in: "99999999999g", wantErr: true},
+		{in: "18446744073709551616", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "mb", wantErr: true},
+		{in: "10x", wantErr: true},
+		{in: "-1", wantErr: true},
+		{in: "1.5g", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseBytes(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseBytes() = %d, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("ParseBytes() = %d %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint64
+		wantErr bool
+	}{
+		{in: "1000", want: 1000},
+		{in: "512kbit", want: 512000},
+		{in: "10Mbit", want: 10000000},
+		{in: "1gbit", want: 1000000000},
+		{in: "1mbps", want: 8000000},
+		{in: "2305843009gbps", want: 2305843009 * 8000000000},
+		{in: "2305843010gbps", wantErr: true},
+		{in: "99999999999gbit", wantErr: true},
+		{in: "0", wantErr: true},
+		{in: "0kbit", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "kbit", wantErr: true},
+		{in: "10mb", wantErr: true},
+		{in: "1.5mbit", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseRate(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseRate() = %d, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("ParseRate() = %d %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
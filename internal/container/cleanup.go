@@ -0,0 +1,60 @@
+package container
+
+import (
+	"time"
+
+	"github.com/truongnhatanh7/xocker/internal/cgroupv2"
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"github.com/truongnhatanh7/xocker/internal/network"
+	"github.com/truongnhatanh7/xocker/internal/state"
+	"go.uber.org/zap"
+)
+
+// markExited records the exit of a container and releases the host resources
+// it was holding. Every step tolerates resources that are already gone, so it
+// is safe to run from both the supervisor and `xocker stop`.
+func markExited(st *state.State, code int) {
+	st.Status = state.StatusExited
+	st.FinishedAt = time.Now()
+	st.ExitCode = code
+	if err := st.Save(); err != nil {
+		logger.Log.Warn("failed to save container state", zap.String("id", st.ID), zap.Error(err))
+	}
+
+	cleanup(st)
+}
+
+func cleanup(st *state.State) {
+	if st.Network != nil {
+		// only bridge networks lease an address
+		if st.Network.IP != "" {
+			if n, err := network.Get(st.Network.Name); err != nil {
+				logger.Log.Warn("failed to find network", zap.String("network", st.Network.Name), zap.Error(err))
+			} else if err := network.ReleaseIP(n, st.ID); err != nil {
+				logger.Log.Warn("failed to release IP", zap.String("ip", st.Network.IP), zap.Error(err))
+			}
+		}
+
+		if len(st.Network.Ports) > 0 {
+			if err := network.UnpublishPorts(st.ID, st.Network.IPv6 != ""); err != nil {
+				logger.Log.Warn("failed to remove port rules", zap.String("id", st.ID), zap.Error(err))
+			}
+		}
+
+		// the veth normally dies with the netns, delete it in case something still holds it
+		if st.Network.HostVeth != "" {
+			if err := network.DeleteVeth(st.Network.HostVeth); err != nil {
+				logger.Log.Warn("failed to delete veth", zap.String("veth", st.Network.HostVeth), zap.Error(err))
+			}
+		}
+	}
+
+	// systemd removes the scopes it owns
+	if st.Cgroup != nil && st.Cgroup.Path != "" && st.Cgroup.Driver != cgroupv2.DriverSystemd {
+		if err := cgroupv2.RemovePath(st.Cgroup.Path); err != nil {
+			logger.Log.Warn("failed to remove cgroup", zap.String("path", st.Cgroup.Path), zap.Error(err))
+		}
+	}
+
+	logger.Log.Debug("cleaned up container", zap.String("id", st.ID))
+}
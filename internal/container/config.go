@@ -0,0 +1,41 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/truongnhatanh7/xocker/internal/state"
+)
+
+const configFile = "config.json"
+
+// saveConfig persists the container as resolved by the CLI (image defaults,
+// flags, ...) so the shim and the container child run exactly the same thing
+func (c *Container) saveConfig() error {
+	dir := state.Dir(c.ID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create state dir %s: %w", dir, err)
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, configFile), data, 0o644)
+}
+
+// LoadConfig reads the config saved for the container with the given ID
+func LoadConfig(id string) (*Container, error) {
+	data, err := os.ReadFile(filepath.Join(state.Dir(id), configFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var c Container
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse config of %s: %w", id, err)
+	}
+	return &c, nil
+}
@@ -1,13 +1,13 @@
 package container
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -16,7 +16,9 @@ import (
 	"github.com/truongnhatanh7/xocker/internal/cgroupv2"
 	"github.com/truongnhatanh7/xocker/internal/common"
 	"github.com/truongnhatanh7/xocker/internal/logger"
+	"github.com/truongnhatanh7/xocker/internal/logs"
 	"github.com/truongnhatanh7/xocker/internal/network"
+	"github.com/truongnhatanh7/xocker/internal/state"
 	"github.com/truongnhatanh7/xocker/internal/sync"
 	"go.uber.org/zap"
 	"golang.org/x/sys/unix"
@@ -24,13 +26,62 @@ import (
 )
 
 type Container struct {
-	Cmd         string
-	Args        []string
-	RootFS      string
-	Flags       []string
+	ID     string
+	Name   string
+	Cmd    string
+	Args   []string
+	RootFS string
+	// set instead of RootFS when running from an image
+	Image     string
+	ImageID   string
+	LowerDirs []string
+	// bind mounts, volumes and tmpfs, resolved to host paths
+	Mounts []state.Mount
+	// published ports, DNAT'ed from the host to the container IP
+	Ports []state.PortMapping
+	// name of the network to attach to, the default network if empty
+	Network        string
+	NetworkAliases []string
+	// tc limits on the host side veth
+	Shaping state.LinkShaping
+	// gateway of the network when its embedded DNS server is used
+	EmbeddedDNS string
+	// generated /etc/hostname, /etc/hosts and /etc/resolv.conf
+	Hostname   string
+	ExtraHosts []string
+	DNS        []string
+	DNSSearch  []string
+	DNSOptions []string
+	// process settings, image config defaults merged with run flags
+	Env         []string
+	WorkingDir  string
+	User        string
 	Interactive bool
-	CPUQuota    uint64
-	Mem         uint64
+	Detach      bool
+	// json-file log rotation, LogMaxSize <= 0 disables it
+	LogMaxSize int64
+	LogMaxFile int
+	// systemd or cgroupfs, picked from the host if empty
+	CgroupDriver string
+	CPUQuota     uint64
+	Mem          uint64
+	// memory+swap in bytes (-1 unlimited, 0 default), memory.low in bytes and
+	// throttling instead of OOM kills at the limit
+	MemorySwap        int64
+	MemoryReservation uint64
+	OOMKillDisable    bool
+	// cpu.weight as docker cpu shares and cpuset pinning
+	CPUShares  uint64
+	CpusetCpus string
+	CpusetMems string
+	// max number of tasks, unlimited if <= 0
+	PidsLimit int64
+	// block I/O weight and per device throttling
+	BlkioWeight     uint16
+	DeviceReadBps   []cgroupv2.DeviceLimit
+	DeviceWriteBps  []cgroupv2.DeviceLimit
+	DeviceReadIOPS  []cgroupv2.DeviceLimit
+	DeviceWriteIOPS []cgroupv2.DeviceLimit
 }
 
 func RunContainer(container *Container) error {
@@ -46,69 +97,121 @@ func RunContainer(container *Container) error {
 		return nil
 	}
 
+	if container.ID == "" {
+		container.ID = state.NewID()
+	}
+	if container.Name == "" {
+		container.Name = state.ShortID(container.ID)
+	}
+	if container.Hostname == "" {
+		container.Hostname = state.ShortID(container.ID)
+	}
+	if err := checkPortsAvailable(container.ID, container.Ports); err != nil {
+		return err
+	}
+
+	if container.Image == "" {
+		// process rootfs dir, "." doesn't work in some cases -> resolve to full path
+		absRootFS, err := filepath.Abs(container.RootFS)
+		common.Must(err)
+		container.RootFS = absRootFS
+
+		// ensure rootfs exists
+		_, err = os.Stat(container.RootFS)
+		common.Must(err)
+	}
+
+	// nil unless the container is attached to a bridge network
+	netw, err := container.resolveNetworkMode()
+	if err != nil {
+		return err
+	}
 	// should be ran via hook or separated cmd, for learning purpose -> create bridge here
-	network.CreateBridge()
+	if netw != nil {
+		network.CreateBridge(netw)
+		container.startEmbeddedDNS(netw)
+	}
 
-	// Initialize IP state file with base IP
-	common.Must(network.InitIPState("./ip.state"))
+	// takes the name, a detached container has it before the CLI returns
+	st := &state.State{
+		ID:      container.ID,
+		Name:    container.Name,
+		Status:  state.StatusCreated,
+		Cmd:     container.Cmd,
+		Args:    container.Args,
+		RootFS:  container.RootFS,
+		Image:   container.Image,
+		Created: time.Now(),
+		LogPath: filepath.Join(state.Dir(container.ID), "container-json.log"),
+		Mounts:  container.Mounts,
+	}
+	if err := st.Create(); err != nil {
+		return err
+	}
+
+	// the shim and the child load this instead of parsing the command line again
+	common.Must(container.saveConfig())
+
+	if container.Detach && !inShim() {
+		return startShim(container)
+	}
 
 	// Create socketpair for parent-child synchronization
 	parentConn, childConn, err := sync.CreateSocketPair()
 	common.Must(err)
 	defer parentConn.Close()
 
-	// process rootfs dir, "." doesn't work in some cases -> resolve to full path
-	absRootFS, err := filepath.Abs(container.RootFS)
-	common.Must(err)
-	container.RootFS = absRootFS
-
-	self, err := os.Executable()
-	common.Must(err)
-
-	// ensure rootfs exists
-	_, err = os.Stat(container.RootFS)
-	common.Must(err)
-
 	// check ps aux count before create ns
 	checkPsAuxCount()
 
-	unshareCmd := []string{
-		"unshare",
-		"--mount",
-		"--uts",
-		"--ipc",
-		"--net",
-		"--pid",
-		"--fork",
-		"--mount-proc",
-	}
-
-	cCmd := append(
-		unshareCmd,
-		"--",
-		self, "run",
-	)
-	cCmd = append(cCmd, container.Flags...)
-	cCmd = append(cCmd, "--")
-	cCmd = append(cCmd, container.Cmd)
-	cCmd = append(cCmd, container.Args...)
-
+	// re-exec ourselves in child mode, the namespaces are created by clone
+	// so the child PID is known as soon as it starts
 	// spawn new ns
-	c := exec.Command(
-		cCmd[0],
-		cCmd[1:]...,
-	)
+	// the child loads the config by _CONTAINER_ID, "run" is all it needs
+	c := exec.Command("/proc/self/exe", "run")
+	cloneflags := syscall.CLONE_NEWNS |
+		syscall.CLONE_NEWUTS |
+		syscall.CLONE_NEWIPC |
+		syscall.CLONE_NEWPID
+	// host mode keeps the host stack, container mode joins another netns in the child
+	if mode, _ := parseNetworkMode(container.Network); mode == network.DriverBridge || mode == NetworkModeNone {
+		cloneflags |= syscall.CLONE_NEWNET
+	}
+	c.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: uintptr(cloneflags),
+	}
 	logger.Log.Debug("c command", zap.String("c", c.String()))
 
 	c.Stdin = os.Stdin
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
 
+	// capture output in the json-file log, the pty of interactive mode is not captured
+	var logStdout, logStderr io.WriteCloser
+	if !container.Interactive {
+		jsonLog, err := logs.NewJSONFile(st.LogPath, container.LogMaxSize, container.LogMaxFile)
+		common.Must(err)
+		defer jsonLog.Close()
+
+		logStdout = jsonLog.Writer("stdout")
+		logStderr = jsonLog.Writer("stderr")
+		c.Stdout = io.MultiWriter(os.Stdout, logStdout)
+		c.Stderr = io.MultiWriter(os.Stderr, logStderr)
+
+		if container.Detach {
+			// detached: nothing to read from, output only goes to the log
+			c.Stdin = nil
+			c.Stdout = logStdout
+			c.Stderr = logStderr
+		}
+	}
+
 	// Pass child side of socketpair to child process via ExtraFiles
 	// The child will access it as fd 3
 	c.ExtraFiles = []*os.File{childConn}
 
 	os.Setenv("_IN_CONTAINER", "1")
+	os.Setenv("_CONTAINER_ID", container.ID)
 	c.Env = os.Environ()
 
 	common.Must(c.Start())
@@ -116,60 +219,125 @@ func RunContainer(container *Container) error {
 	// Close child conn in parent (child has its own copy)
 	childConn.Close()
 
-	realPid, err := waitForChildPID(c.Process.Pid, 3*time.Second) // choose an appropriate timeout
-	if err != nil {
-		// best effort fallback: if we cannot find child, kill and return error
-		c.Process.Kill()
-		common.Must(fmt.Errorf("could not find forked child of unshare (%d): %w", c.Process.Pid, err))
-	}
+	realPid := c.Process.Pid
 	logger.Log.Debug("realpid", zap.Int("pid", realPid))
 
-	// Set up container networking from parent (host namespace)
-	containerIP, vethName, err := network.CreateVethAndAttachToBridge(realPid)
+	// the child waits on fd 3, so nothing it runs can escape the limits
+	cg, err := cgroupv2.NewDriver(container.CgroupDriver, container.ID)
 	if err != nil {
-		c.Process.Kill()
-		common.Must(fmt.Errorf("failed to set up container network: %w", err))
-	}
-	logger.Log.Info("network configured for container",
-		zap.String("ip", containerIP),
-		zap.String("veth", vethName),
-		zap.Int("pid", realPid))
-
-	// Prepare network configuration to send to child
-	networkConfig := fmt.Sprintf("%s\n%s\n%s", containerIP, vethName, "172.18.0.1")
-
-	// Signal child that network is ready and send config
-	if err := sync.SignalReady(parentConn, networkConfig); err != nil {
-		c.Process.Kill()
-		common.Must(fmt.Errorf("failed to signal child: %w", err))
+		return abortStart(c, st, err)
 	}
-	logger.Log.Debug("signaled child that network is ready")
-
-	// Set up cgroups
-	cg := cgroupv2.NewCgroupV2("container_" + time.Now().Format(time.RFC3339Nano))
-	cg.Limit(&cgroupv2.CgroupV2SetSpecs{
+	err = cg.Limit(&cgroupv2.CgroupV2SetSpecs{
 		ApplyToPid: realPid,
 		CPUSpec: &cgroupv2.CPUSpec{
-			Quota: container.CPUQuota,
+			Quota:  container.CPUQuota,
+			Shares: container.CPUShares,
+			Cpus:   container.CpusetCpus,
+			Mems:   container.CpusetMems,
 		},
 		MemSpec: &cgroupv2.MemSpec{
-			Limit: container.Mem,
+			Limit:          container.Mem,
+			Swap:           container.MemorySwap,
+			Reservation:    container.MemoryReservation,
+			OOMKillDisable: container.OOMKillDisable,
+		},
+		PidsSpec: &cgroupv2.PidsSpec{
+			Limit: container.PidsLimit,
+		},
+		IOSpec: &cgroupv2.IOSpec{
+			Weight:    container.BlkioWeight,
+			ReadBps:   container.DeviceReadBps,
+			WriteBps:  container.DeviceWriteBps,
+			ReadIOPS:  container.DeviceReadIOPS,
+			WriteIOPS: container.DeviceWriteIOPS,
 		},
 	})
+	if err != nil {
+		cg.Destroy()
+		return abortStart(c, st, fmt.Errorf("failed to set up cgroup: %w", err))
+	}
 	defer cg.Destroy()
+	st.Cgroup = &state.CgroupState{
+		Driver: cg.Name(),
+		Path:   cg.Path(),
+		Unit:   cg.Unit(),
+	}
 
-	if err := c.Wait(); err != nil {
-		logger.Log.Error("container process exited with error", zap.Error(err))
-		// Clean up IP before returning error
-		justIP := strings.Split(containerIP, "/")[0]
-		network.ReleaseIP("./ip.state", justIP)
-		return fmt.Errorf("container exited with error: %w", err)
+	// Set up container networking from parent (host namespace)
+	netState := &state.NetworkState{Name: container.Network}
+	if netw != nil {
+		netState, err = setupBridgeNetwork(container, netw, realPid)
+		if err != nil {
+			return abortStart(c, st, err)
+		}
 	}
+	st.Network = netState
 
-	// Clean up IP allocation when container exits
-	justIP := strings.Split(containerIP, "/")[0]
-	if err := network.ReleaseIP("./ip.state", justIP); err != nil {
-		logger.Log.Warn("failed to release IP", zap.String("ip", justIP), zap.Error(err))
+	// Signal child that it can go on, with the network config in bridge mode
+	if err := sync.SignalReady(parentConn, childNetworkConfig(netState)); err != nil {
+		return abortStart(c, st, fmt.Errorf("failed to signal child: %w", err))
+	}
+	// without a config the child reads until EOF
+	parentConn.Close()
+	logger.Log.Debug("signaled child that it is ready to run")
+
+	oom, err := cgroupv2.WatchOOM(cg.Path())
+	if err != nil {
+		logger.Log.Warn("failed to watch OOM kills", zap.String("id", container.ID), zap.Error(err))
+	} else {
+		defer oom.Close()
+	}
+
+	st.Status = state.StatusRunning
+	st.Pid = realPid
+	st.SupervisorPid = os.Getpid()
+	st.StartedAt = time.Now()
+	// a zero start time only disables the PID reuse checks
+	if st.PidStartTime, err = processStartTime(realPid); err != nil {
+		logger.Log.Warn("failed to read the container start time", zap.String("id", container.ID), zap.Error(err))
+	}
+	if st.SupervisorStartTime, err = processStartTime(st.SupervisorPid); err != nil {
+		logger.Log.Warn("failed to read the supervisor start time", zap.String("id", container.ID), zap.Error(err))
+	}
+	if err := st.Save(); err != nil {
+		logger.Log.Warn("failed to save container state", zap.String("id", container.ID), zap.Error(err))
+	}
+
+	if container.Detach {
+		signalShimReady(container.ID)
+	}
+
+	// proxy signals sent to the supervisor to the container instead of dying,
+	// so the cleanup below still runs
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+	go func() {
+		for sig := range sigCh {
+			logger.Log.Debug("forwarding signal to container", zap.String("signal", sig.String()))
+			unix.Kill(realPid, sig.(syscall.Signal))
+		}
+	}()
+
+	waitErr := c.Wait()
+	// read before the cgroup goes away, systemd removes an empty scope right away
+	st.OOMKilled = oom != nil && oom.Killed()
+
+	if logStdout != nil {
+		// flush partial lines
+		logStdout.Close()
+		logStderr.Close()
+	}
+
+	// Clean up IP, veth and cgroup when container exits, whoever stopped it
+	markExited(st, exitCode(waitErr))
+
+	if st.OOMKilled {
+		return fmt.Errorf("container was OOM killed, exit code %d", st.ExitCode)
+	}
+	if waitErr != nil {
+		logger.Log.Error("container process exited with error", zap.Error(waitErr))
+		return fmt.Errorf("container exited with error: %w", waitErr)
 	}
 
 	return nil
@@ -185,16 +353,30 @@ func handleChild(container *Container) error {
 	myPid := os.Getpid()
 	logger.Log.Debug("child process started", zap.Int("pid", myPid))
 
-	time.Sleep(100 * time.Millisecond)
+	// the parent signals once we are in the cgroup and the network is set up
+	networkConfig, err := sync.WaitForReady(childConn, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("timeout waiting for the parent: %w", err)
+	}
+	logger.Log.Debug("received ready signal from parent")
+
+	// don't leak the mounts below to the host's mount namespace
+	common.Must(unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""))
 
-	mergedRootFS := container.RootFS + "/../merged"
+	// writable layer and mountpoint belong to this container only, they are
+	// kept in its state dir until `xocker rm`
+	dir := state.Dir(container.ID)
+	mergedRootFS := dir + "/merged"
 
-	common.Must(os.MkdirAll(container.RootFS+"/../merged", 0755))
-	common.Must(os.MkdirAll(container.RootFS+"/../overlay/upper", 0755))
-	common.Must(os.MkdirAll(container.RootFS+"/../overlay/work", 0755))
+	common.Must(os.MkdirAll(dir+"/merged", 0755))
+	common.Must(os.MkdirAll(dir+"/upper", 0755))
+	common.Must(os.MkdirAll(dir+"/work", 0755))
 	lower := container.RootFS
-	upper := container.RootFS + "/../overlay/upper"
-	work := container.RootFS + "/../overlay/work"
+	if len(container.LowerDirs) > 0 {
+		lower = strings.Join(container.LowerDirs, ":")
+	}
+	upper := dir + "/upper"
+	work := dir + "/work"
 	opts := "lowerdir=" + lower + ",upperdir=" + upper + ",workdir=" + work
 	common.Must(syscall.Mount("overlay", mergedRootFS, "overlay", 0, opts))
 
@@ -222,42 +404,34 @@ func handleChild(container *Container) error {
 	common.Must(mknodChar(mergedRootFS+"/dev/random", 0o666, 1, 8))
 	common.Must(mknodChar(mergedRootFS+"/dev/urandom", 0o666, 1, 9))
 
+	if err := mountAll(mergedRootFS, container.Mounts); err != nil {
+		return fmt.Errorf("failed to set up mounts: %w", err)
+	}
+
 	logger.Log.Debug("done mounting")
 
-	logger.Log.Debug("child waiting for network setup signal from parent")
-	networkConfig, err := sync.WaitForReady(childConn, 10*time.Second)
+	containerIPs, err := setupChildNetwork(container, networkConfig)
 	if err != nil {
-		return fmt.Errorf("timeout waiting for network setup: %w", err)
+		return err
 	}
-	logger.Log.Debug("received network ready signal from parent")
 
-	configLines := strings.Split(strings.TrimSpace(networkConfig), "\n")
-	if len(configLines) != 3 {
-		return fmt.Errorf("invalid network config format, expected 3 lines, got %d", len(configLines))
+	etcMounts, err := container.writeEtcFiles(containerIPs)
+	if err != nil {
+		return fmt.Errorf("failed to generate /etc files: %w", err)
 	}
-
-	containerIP := configLines[0]
-	vethName := configLines[1]
-	gatewayIP := configLines[2]
-
-	logger.Log.Debug("network config received",
-		zap.String("ip", containerIP),
-		zap.String("veth", vethName),
-		zap.String("gateway", gatewayIP))
-
-	// Configure network inside container namespace
-	if err := network.ConfigureContainerNetwork(vethName, containerIP, gatewayIP); err != nil {
-		return fmt.Errorf("failed to configure container network: %w", err)
+	if err := mountAll(mergedRootFS, etcMounts); err != nil {
+		return fmt.Errorf("failed to mount /etc files: %w", err)
 	}
+	common.Must(unix.Sethostname([]byte(container.Hostname)))
 
 	// pivot root
 	// make newroot a mountpoint
 	//
-	_, err = os.Stat(container.RootFS)
+	_, err = os.Stat(mergedRootFS)
 	common.Must(err)
 	unix.Mount(mergedRootFS, mergedRootFS, "", unix.MS_BIND|unix.MS_REC, "")
-	common.Must(os.MkdirAll(container.RootFS+"/../merged/old_root", 0o777))
-	common.Must(unix.PivotRoot(container.RootFS+"/../merged", container.RootFS+"/../merged/old_root"))
+	common.Must(os.MkdirAll(mergedRootFS+"/old_root", 0o777))
+	common.Must(unix.PivotRoot(mergedRootFS, mergedRootFS+"/old_root"))
 	common.Must(os.Chdir("/"))
 	common.Must(unix.Unmount("./old_root", syscall.MNT_DETACH))
 	logger.Log.Debug("done pivot root")
@@ -267,54 +441,130 @@ func handleChild(container *Container) error {
 	argv = append(argv, container.Args...)
 	logger.Log.Debug("argv", zap.Strings("argv", argv))
 
-	// rename hostname of container
-	chc := exec.Command("hostname", "container_"+time.Now().Format(time.RFC3339Nano))
-	common.Must(chc.Start())
+	// resolved against the container's own /etc/passwd and PATH
+	u, err := lookupUser(container.User)
+	if err != nil {
+		return err
+	}
+	env := withDefaultEnv(container.Env, u.Home)
+
+	workdir := container.WorkingDir
+	if workdir == "" {
+		workdir = "/"
+	}
+	common.Must(os.MkdirAll(workdir, 0o755))
+	common.Must(os.Chdir(workdir))
+
+	path, err := lookPath(container.Cmd, env)
+	if err != nil {
+		return err
+	}
+	logger.Log.Debug("process",
+		zap.String("path", path),
+		zap.Strings("env", env),
+		zap.String("workdir", workdir),
+		zap.Uint32("uid", u.Uid),
+		zap.Uint32("gid", u.Gid))
 
 	if container.Interactive {
 		// use exec command insteaqd of syscall.Exec to maintain connection
 		// with go runtime, cuz we're setting up pty master-slave, ...
-		cmd := exec.Command(container.Cmd)
+		cmd := exec.Command(path, container.Args...)
+		cmd.Args[0] = container.Cmd
+		cmd.Env = append(env, "TERM="+os.Getenv("TERM"))
+		cmd.Dir = workdir
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Credential: &syscall.Credential{Uid: u.Uid, Gid: u.Gid, Groups: u.Groups},
+		}
+		code, err := commandExitCode(runWithPTY(cmd))
+		if err != nil {
+			return fmt.Errorf("failed to run %s: %w", container.Cmd, err)
+		}
+		// the supervisor reports the exit code of this process as the container's
+		os.Exit(code)
+	}
 
-		// start pty
-		ptmx, err := pty.Start(cmd)
-		common.Must(err)
-		defer ptmx.Close()
+	// drop privileges last, groups first while we are still root
+	groups := make([]int, len(u.Groups))
+	for i, g := range u.Groups {
+		groups[i] = int(g)
+	}
+	common.Must(syscall.Setgroups(groups))
+	common.Must(syscall.Setgid(int(u.Gid)))
+	common.Must(syscall.Setuid(int(u.Uid)))
 
-		// turn off canonical mode
-		oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
-		common.Must(err)
-		defer term.Restore(int(os.Stdin.Fd()), oldState)
+	common.Must(syscall.Exec(path, argv, env))
 
-		// handle resize -> propagate resize events
-		go func() {
-			ch := make(chan os.Signal, 1)
-			signal.Notify(ch, syscall.SIGWINCH)
+	return nil
+}
 
-			go func() {
-				for range ch {
-					pty.InheritSize(os.Stdin, ptmx)
-				}
-			}()
-			ch <- syscall.SIGWINCH
-		}()
+// exitCode maps the error returned by Wait to a shell-style exit code
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
 
-		// copy io
-		go func() {
-			//user input -> master
-			io.Copy(ptmx, os.Stdin)
-		}()
-		// master output -> stdout
-		io.Copy(os.Stdout, ptmx)
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return -1
+	}
 
-		// call wait to properly clean up
-		common.Must(cmd.Wait())
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return exitErr.ExitCode()
+}
 
-		return nil
+// commandExitCode maps the error of running a command to its exit code, any
+// other error than a non-zero exit means it could not run and is returned
+func commandExitCode(err error) (int, error) {
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return -1, err
+	}
+	return exitCode(err), nil
+}
+
+// runWithPTY starts cmd on a new pty and wires it to the current terminal,
+// which is put in raw mode until cmd exits
+func runWithPTY(cmd *exec.Cmd) error {
+	// turn off canonical mode, before starting so a non terminal stdin fails early
+	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return fmt.Errorf("failed to put the terminal in raw mode: %w", err)
 	}
-	common.Must(syscall.Exec(container.Cmd, argv, []string{}))
+	defer term.Restore(int(os.Stdin.Fd()), oldState)
 
-	return nil
+	// start pty
+	ptmx, err := pty.Start(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to start on a pty: %w", err)
+	}
+	defer ptmx.Close()
+
+	// handle resize -> propagate resize events
+	go func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, syscall.SIGWINCH)
+
+		go func() {
+			for range ch {
+				pty.InheritSize(os.Stdin, ptmx)
+			}
+		}()
+		ch <- syscall.SIGWINCH
+	}()
+
+	// copy io
+	go func() {
+		//user input -> master
+		io.Copy(ptmx, os.Stdin)
+	}()
+	// master output -> stdout
+	io.Copy(os.Stdout, ptmx)
+
+	// call wait to properly clean up
+	return cmd.Wait()
 }
 
 func checkPsAuxCount() {
@@ -370,33 +620,145 @@ func mknodChar(path string, perm uint32, major, minor uint32) error {
 	return os.Chmod(path, os.FileMode(perm))
 }
 
-func waitForChildPID(unsharePid int, timeout time.Duration) (int, error) {
-	deadline := time.Now().Add(timeout)
-	for {
-		pid, err := getChildPID(unsharePid)
-		if err == nil {
-			return pid, nil
+// setupChildNetwork finishes the network setup inside the container with the
+// config sent by the parent, it returns the container addresses in bridge
+// mode, IPv4 first
+func setupChildNetwork(container *Container, networkConfig string) ([]string, error) {
+	mode, target := parseNetworkMode(container.Network)
+	switch mode {
+	case NetworkModeHost:
+		return nil, nil
+	case NetworkModeNone:
+		// own netns with loopback only
+		if err := network.LinkSetUp("lo"); err != nil {
+			return nil, fmt.Errorf("failed to bring up loopback interface: %w", err)
 		}
-		// if children file exists but is empty, retry until timeout
-		if time.Now().After(deadline) {
-			return 0, fmt.Errorf("timed out waiting for child of %d: last error: %w", unsharePid, err)
+		return nil, nil
+	case NetworkModeContainer:
+		return nil, joinContainerNetwork(target)
+	}
+
+	configLines := strings.Split(networkConfig, "\n")
+	if len(configLines) != 5 {
+		return nil, fmt.Errorf("invalid network config format, expected 5 lines, got %d", len(configLines))
+	}
+
+	containerIP := configLines[0]
+	vethName := configLines[1]
+	gatewayIP := configLines[2]
+	containerIPv6 := configLines[3]
+	gatewayV6 := configLines[4]
+
+	logger.Log.Debug("network config received",
+		zap.String("ip", containerIP),
+		zap.String("veth", vethName),
+		zap.String("gateway", gatewayIP),
+		zap.String("ipv6", containerIPv6),
+		zap.String("gatewayV6", gatewayV6))
+
+	// Configure network inside container namespace
+	if err := network.ConfigureContainerNetwork(vethName, containerIP, gatewayIP, containerIPv6, gatewayV6); err != nil {
+		return nil, fmt.Errorf("failed to configure container network: %w", err)
+	}
+
+	addrs := []string{strings.Split(containerIP, "/")[0]}
+	if containerIPv6 != "" {
+		addrs = append(addrs, strings.Split(containerIPv6, "/")[0])
+	}
+	return addrs, nil
+}
+
+// abortStart kills and reaps the child of a failed start, releases what it was
+// given and returns err
+func abortStart(c *exec.Cmd, st *state.State, err error) error {
+	c.Process.Kill()
+	c.Wait()
+	markExited(st, 128+int(syscall.SIGKILL))
+	return err
+}
+
+// setupBridgeNetwork attaches the container to the bridge and publishes its
+// ports. On failure nothing is left attached to the bridge.
+func setupBridgeNetwork(container *Container, netw *network.Network, pid int) (_ *state.NetworkState, err error) {
+	containerIP, containerIPv6, hostVeth, vethName, err := network.CreateVethAndAttachToBridge(container.ID, pid, netw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up container network: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			if len(container.Ports) > 0 {
+				network.UnpublishPorts(container.ID, containerIPv6 != "")
+			}
+			network.DeleteVeth(hostVeth)
+			network.ReleaseIP(netw, container.ID)
 		}
-		time.Sleep(10 * time.Millisecond)
+	}()
+	logger.Log.Info("network configured for container",
+		zap.String("ip", containerIP),
+		zap.String("ipv6", containerIPv6),
+		zap.String("veth", vethName),
+		zap.Int("pid", pid))
+
+	justIP := strings.Split(containerIP, "/")[0]
+	justIPv6 := strings.Split(containerIPv6, "/")[0]
+	if err := network.PublishPorts(container.ID, justIP, justIPv6, hostVeth, netw.Bridge, container.Ports); err != nil {
+		return nil, fmt.Errorf("failed to publish ports: %w", err)
+	}
+	if err := network.ShapeLink(hostVeth, container.Shaping); err != nil {
+		return nil, err
 	}
+
+	var shaping *state.LinkShaping
+	if !container.Shaping.IsZero() {
+		shaping = &container.Shaping
+	}
+
+	return &state.NetworkState{
+		Name:      netw.Name,
+		IP:        containerIP,
+		Gateway:   netw.Gateway,
+		IPv6:      containerIPv6,
+		GatewayV6: netw.GatewayV6,
+		Bridge:    netw.Bridge,
+		Veth:      vethName,
+		HostVeth:  hostVeth,
+		Ports:     container.Ports,
+		Aliases:   container.NetworkAliases,
+		Shaping:   shaping,
+	}, nil
 }
-func getChildPID(unsharePid int) (int, error) {
-	// path like: /proc/1234/task/1234/children
-	path := fmt.Sprintf("/proc/%d/task/%d/children", unsharePid, unsharePid)
-	b, err := os.ReadFile(path)
-	common.Must(err)
 
-	s := strings.TrimSpace(string(b))
-	if s == "" {
-		return 0, fmt.Errorf("no children for %d", unsharePid)
+// childNetworkConfig is the network config sent to the child, empty unless
+// it has a veth to configure. The IPv6 lines are empty on IPv4 only networks.
+func childNetworkConfig(n *state.NetworkState) string {
+	if n.Veth == "" {
+		return ""
 	}
-	parts := strings.Fields(s)
-	pid, err := strconv.Atoi(parts[0])
-	common.Must(err)
+	return fmt.Sprintf("%s\n%s\n%s\n%s\n%s", n.IP, n.Veth, n.Gateway, n.IPv6, n.GatewayV6)
+}
 
-	return pid, nil
+// checkPortsAvailable fails if another running container already publishes
+// one of the host ports
+func checkPortsAvailable(id string, ports []state.PortMapping) error {
+	if len(ports) == 0 {
+		return nil
+	}
+	states, err := state.List()
+	if err != nil {
+		return err
+	}
+	for _, s := range states {
+		if s.ID == id || s.Status == state.StatusExited || s.Network == nil {
+			continue
+		}
+		for _, used := range s.Network.Ports {
+			for _, p := range ports {
+				if p.HostPort == used.HostPort && p.Protocol == used.Protocol &&
+					(p.HostIP == "" || used.HostIP == "" || p.HostIP == used.HostIP) {
+					return fmt.Errorf("host port %d/%s is already published by container %s", p.HostPort, p.Protocol, s.Name)
+				}
+			}
+		}
+	}
+	return nil
 }
@@ -0,0 +1,35 @@
+package container
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+func TestCommandExitCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmd      *exec.Cmd
+		wantCode int
+		wantErr  bool
+	}{
+		{"success", exec.Command("true"), 0, false},
+		{"non-zero exit", exec.Command("sh", "-c", "exit 3"), 3, false},
+		{"killed", exec.Command("sh", "-c", "kill -9 $$"), 137, false},
+		{"start failure", exec.Command("/nonexistent/cmd"), -1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := commandExitCode(tt.cmd.Run())
+			if code != tt.wantCode {
+				t.Errorf("code = %d, want %d", code, tt.wantCode)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+	if _, err := commandExitCode(errors.New("pty allocation failed")); err == nil {
+		t.Error("a non exit error was mapped to an exit code")
+	}
+}
@@ -0,0 +1,137 @@
+package container
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/truongnhatanh7/xocker/internal/dns"
+	"github.com/truongnhatanh7/xocker/internal/state"
+)
+
+var validHostname = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*$`)
+
+// ValidateHostname checks a --hostname value
+func ValidateHostname(name string) error {
+	if len(name) > 64 || !validHostname.MatchString(name) {
+		return fmt.Errorf("invalid hostname %q", name)
+	}
+	return nil
+}
+
+// ParseExtraHost parses an --add-host entry, host:ip
+func ParseExtraHost(spec string) (string, error) {
+	host, ip, ok := strings.Cut(spec, ":")
+	if !ok || host == "" {
+		return "", fmt.Errorf("invalid extra host %q, expected host:ip", spec)
+	}
+	addr, err := netip.ParseAddr(strings.Trim(ip, "[]"))
+	if err != nil {
+		return "", fmt.Errorf("invalid IP in extra host %q: %w", spec, err)
+	}
+	return host + ":" + addr.String(), nil
+}
+
+// ValidateDNS checks a --dns value
+func ValidateDNS(ip string) error {
+	if _, err := netip.ParseAddr(ip); err != nil {
+		return fmt.Errorf("invalid DNS server %q: %w", ip, err)
+	}
+	return nil
+}
+
+func (c *Container) resolvConf() []byte {
+	mode, _ := parseNetworkMode(c.Network)
+	conf := dns.HostResolvConf(mode == NetworkModeHost)
+	switch {
+	case len(c.DNS) > 0:
+		conf.Nameservers = c.DNS
+	case c.EmbeddedDNS != "":
+		// resolves the containers of the network, forwards the rest to the host's resolvers
+		conf.Nameservers = []string{c.EmbeddedDNS}
+	}
+	if len(c.DNSSearch) > 0 {
+		conf.Search = c.DNSSearch
+	}
+	if len(c.DNSOptions) > 0 {
+		conf.Options = c.DNSOptions
+	}
+	return []byte(conf.String())
+}
+
+func (c *Container) hostsFile(ips []string) []byte {
+	var b strings.Builder
+	b.WriteString("# generated by xocker\n")
+	b.WriteString("127.0.0.1\tlocalhost\n")
+	b.WriteString("::1\tlocalhost ip6-localhost ip6-loopback\n")
+
+	names := c.Hostname
+	if c.Name != "" && c.Name != c.Hostname {
+		names += " " + c.Name
+	}
+	if len(ips) == 0 {
+		ips = []string{"127.0.1.1"}
+	}
+	for _, ip := range ips {
+		fmt.Fprintf(&b, "%s\t%s\n", ip, names)
+	}
+
+	for _, h := range c.ExtraHosts {
+		host, addr, _ := strings.Cut(h, ":")
+		fmt.Fprintf(&b, "%s\t%s\n", addr, host)
+	}
+	return []byte(b.String())
+}
+
+// writeEtcFiles generates /etc/resolv.conf, /etc/hosts and /etc/hostname in
+// the state dir and returns the bind mounts putting them in the container.
+// Files the user mounts explicitly are left alone, in container network mode
+// resolv.conf and hosts of the target container are shared.
+func (c *Container) writeEtcFiles(ips []string) ([]state.Mount, error) {
+	dir := state.Dir(c.ID)
+	files := map[string]string{
+		"/etc/hostname":    filepath.Join(dir, "hostname"),
+		"/etc/hosts":       filepath.Join(dir, "hosts"),
+		"/etc/resolv.conf": filepath.Join(dir, "resolv.conf"),
+	}
+
+	if err := os.WriteFile(files["/etc/hostname"], []byte(c.Hostname+"\n"), 0o644); err != nil {
+		return nil, err
+	}
+	if mode, target := parseNetworkMode(c.Network); mode == NetworkModeContainer {
+		files["/etc/hosts"] = filepath.Join(state.Dir(target), "hosts")
+		files["/etc/resolv.conf"] = filepath.Join(state.Dir(target), "resolv.conf")
+	} else {
+		if err := os.WriteFile(files["/etc/hosts"], c.hostsFile(ips), 0o644); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(files["/etc/resolv.conf"], c.resolvConf(), 0o644); err != nil {
+			return nil, err
+		}
+	}
+
+	var mounts []state.Mount
+	for _, target := range []string{"/etc/hostname", "/etc/hosts", "/etc/resolv.conf"} {
+		if c.mountsOver(target) {
+			continue
+		}
+		mounts = append(mounts, state.Mount{
+			Type:   state.MountTypeBind,
+			Source: files[target],
+			Target: target,
+		})
+	}
+	return mounts, nil
+}
+
+func (c *Container) mountsOver(target string) bool {
+	for _, m := range c.Mounts {
+		if filepath.Clean(m.Target) == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package container
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseExtraHost(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{"db:10.0.0.5", "db:10.0.0.5", false},
+		{"db:fd00::5", "db:fd00::5", false},
+		{"db:[fd00::5]", "db:fd00::5", false},
+		{"db:fd00:0::5", "db:fd00::5", false},
+		{"db", "", true},
+		{":10.0.0.5", "", true},
+		{"db:not-an-ip", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseExtraHost(tt.spec)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Fatalf("ParseExtraHost() = %q %v, want %q error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateHostname(t *testing.T) {
+	for _, name := range []string{"web", "web-1", "a.b.c", "0abc"} {
+		if err := ValidateHostname(name); err != nil {
+			t.Errorf("ValidateHostname(%q) = %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "-web", "web-", "a..b", "we_b", strings.Repeat("a", 65)} {
+		if err := ValidateHostname(name); err == nil {
+			t.Errorf("ValidateHostname(%q) accepted", name)
+		}
+	}
+}
+
+func TestHostsFile(t *testing.T) {
+	c := &Container{Name: "web", Hostname: "abc123", ExtraHosts: []string{"db:fd00::5"}}
+	want := "# generated by xocker\n" +
+		"127.0.0.1\tlocalhost\n" +
+		"::1\tlocalhost ip6-localhost ip6-loopback\n" +
+		"172.18.0.2\tabc123 web\n" +
+		"fd00::2\tabc123 web\n" +
+		"fd00::5\tdb\n"
+	if got := string(c.hostsFile([]string{"172.18.0.2", "fd00::2"})); got != want {
+		t.Fatalf("hostsFile() = %q, want %q", got, want)
+	}
+
+	// without an address the names point to a loopback address like on debian
+	c = &Container{Name: "abc123", Hostname: "abc123"}
+	want = "# generated by xocker\n" +
+		"127.0.0.1\tlocalhost\n" +
+		"::1\tlocalhost ip6-localhost ip6-loopback\n" +
+		"127.0.1.1\tabc123\n"
+	if got := string(c.hostsFile(nil)); got != want {
+		t.Fatalf("hostsFile() = %q, want %q", got, want)
+	}
+}
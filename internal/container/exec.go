@@ -0,0 +1,169 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/truongnhatanh7/xocker/internal/cgroupv2"
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"github.com/truongnhatanh7/xocker/internal/state"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+const defaultPath = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+type ExecOptions struct {
+	Cmd         string
+	Args        []string
+	Interactive bool
+	// defaults to the environment, working dir and user of the container
+	Env        []string
+	WorkingDir string
+	User       string
+}
+
+// namespaces joined by exec, mnt has to be last: once we are in it the
+// host /proc is no longer reachable
+var execNamespaces = []struct {
+	name string
+	flag int
+}{
+	{"ipc", unix.CLONE_NEWIPC},
+	{"uts", unix.CLONE_NEWUTS},
+	{"net", unix.CLONE_NEWNET},
+	{"pid", unix.CLONE_NEWPID},
+	{"mnt", unix.CLONE_NEWNS},
+}
+
+// Exec runs a command inside the namespaces and cgroup of a running container
+// and returns its exit code. An error means the command could not be run.
+func Exec(st *state.State, opts *ExecOptions) (int, error) {
+	if st.Status != state.StatusRunning || !processAlive(st.Pid, st.PidStartTime) {
+		return -1, fmt.Errorf("container %s is not running", st.Name)
+	}
+
+	// open everything we need from the host before switching namespaces
+	nsFds := make([]int, 0, len(execNamespaces))
+	defer func() {
+		for _, fd := range nsFds {
+			unix.Close(fd)
+		}
+	}()
+	for _, ns := range execNamespaces {
+		path := fmt.Sprintf("/proc/%d/ns/%s", st.Pid, ns.name)
+		fd, err := unix.Open(path, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return -1, fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		nsFds = append(nsFds, fd)
+	}
+
+	cgroupDir, err := openProcessCgroup(st.Pid)
+	if err != nil {
+		logger.Log.Warn("exec will not join the container cgroup", zap.Error(err))
+	} else {
+		defer cgroupDir.Close()
+	}
+
+	// setns only affects the calling thread, keep this goroutine on it and
+	// never unlock: the runtime throws the tainted thread away when we are done
+	runtime.LockOSThread()
+
+	// joining a mount namespace requires not sharing fs attributes with other threads
+	if err := unix.Unshare(unix.CLONE_FS); err != nil {
+		return -1, fmt.Errorf("failed to unshare fs attributes: %w", err)
+	}
+	for i, ns := range execNamespaces {
+		if err := unix.Setns(nsFds[i], ns.flag); err != nil {
+			return -1, fmt.Errorf("failed to join %s namespace: %w", ns.name, err)
+		}
+	}
+	logger.Log.Debug("joined container namespaces", zap.String("id", st.ID), zap.Int("pid", st.Pid))
+
+	// from here on path and user lookups happen inside the container's root
+	u, err := lookupUser(opts.User)
+	if err != nil {
+		return -1, err
+	}
+	env := withDefaultEnv(opts.Env, u.Home)
+	path, err := lookPath(opts.Cmd, env)
+	if err != nil {
+		return -1, err
+	}
+	cmd := exec.Command(path, opts.Args...)
+	cmd.Args[0] = opts.Cmd
+	cmd.Dir = opts.WorkingDir
+	if cmd.Dir == "" {
+		cmd.Dir = "/"
+	}
+	cmd.Env = env
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: u.Uid, Gid: u.Gid, Groups: u.Groups},
+	}
+	if cgroupDir != nil {
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(cgroupDir.Fd())
+	}
+
+	if opts.Interactive {
+		cmd.Env = append(cmd.Env, "TERM="+os.Getenv("TERM"))
+		code, err := commandExitCode(runWithPTY(cmd))
+		if err != nil {
+			return -1, fmt.Errorf("failed to run %s: %w", opts.Cmd, err)
+		}
+		return code, nil
+	}
+
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return -1, fmt.Errorf("failed to start %s: %w", opts.Cmd, err)
+	}
+	return commandExitCode(cmd.Wait())
+}
+
+// openProcessCgroup opens the cgroup v2 directory pid belongs to
+func openProcessCgroup(pid int) (*os.File, error) {
+	path, err := processCgroupPath(pid)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// processCgroupPath returns the cgroup v2 directory pid belongs to
+func processCgroupPath(pid int) (string, error) {
+	var fs unix.Statfs_t
+	if err := unix.Statfs(cgroupv2.Root, &fs); err != nil {
+		return "", err
+	}
+	if fs.Type != unix.CGROUP2_SUPER_MAGIC {
+		return "", fmt.Errorf("%s is not a cgroup v2 mount", cgroupv2.Root)
+	}
+
+	f, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// unified hierarchy line looks like: 0::/system.slice/xocker-1234.scope
+		if rel, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			return filepath.Join(cgroupv2.Root, rel), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no cgroup v2 entry for pid %d", pid)
+}
@@ -0,0 +1,276 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/truongnhatanh7/xocker/internal/common"
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"github.com/truongnhatanh7/xocker/internal/state"
+	"github.com/truongnhatanh7/xocker/internal/volume"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// ParseVolumeFlag parses -v host:container[:ro|rw], a host part that is not a
+// path is the name of a volume
+func ParseVolumeFlag(spec string) (state.Mount, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return state.Mount{}, fmt.Errorf("invalid volume %q, expected host:container[:ro]", spec)
+	}
+
+	m := state.Mount{Type: state.MountTypeBind, Source: parts[0], Target: parts[1]}
+	if !strings.HasPrefix(m.Source, "/") && !strings.HasPrefix(m.Source, ".") {
+		m.Type = state.MountTypeVolume
+		m.Name = m.Source
+		m.Source = ""
+	}
+
+	if len(parts) == 3 {
+		for _, opt := range strings.Split(parts[2], ",") {
+			switch opt {
+			case "ro":
+				m.ReadOnly = true
+			case "rw":
+				m.ReadOnly = false
+			default:
+				return state.Mount{}, fmt.Errorf("invalid volume option %q in %q", opt, spec)
+			}
+		}
+	}
+	return m, validateMount(m)
+}
+
+// ParseMountFlag parses --mount type=bind|volume|tmpfs,source=..,target=..[,readonly]
+func ParseMountFlag(spec string) (state.Mount, error) {
+	m := state.Mount{Type: state.MountTypeVolume}
+	for _, field := range strings.Split(spec, ",") {
+		key, value, hasValue := strings.Cut(field, "=")
+		switch strings.ToLower(key) {
+		case "type":
+			m.Type = value
+		case "source", "src":
+			m.Source = value
+		case "target", "destination", "dst":
+			m.Target = value
+		case "readonly", "ro":
+			ro := true
+			if hasValue {
+				var err error
+				if ro, err = strconv.ParseBool(value); err != nil {
+					return state.Mount{}, fmt.Errorf("invalid readonly value %q", value)
+				}
+			}
+			m.ReadOnly = ro
+		case "tmpfs-size":
+			size, err := common.ParseBytes(value)
+			if err != nil {
+				return state.Mount{}, err
+			}
+			m.TmpfsSize = size
+		case "tmpfs-mode":
+			mode, err := strconv.ParseUint(value, 8, 32)
+			if err != nil {
+				return state.Mount{}, fmt.Errorf("invalid tmpfs-mode %q", value)
+			}
+			m.TmpfsMode = uint32(mode)
+		default:
+			return state.Mount{}, fmt.Errorf("unknown mount option %q", key)
+		}
+	}
+
+	switch m.Type {
+	case state.MountTypeVolume:
+		m.Name = m.Source
+		m.Source = ""
+	case state.MountTypeBind:
+	case state.MountTypeTmpfs:
+		if m.Source != "" {
+			return state.Mount{}, fmt.Errorf("tmpfs mounts do not take a source")
+		}
+	default:
+		return state.Mount{}, fmt.Errorf("unsupported mount type %q", m.Type)
+	}
+	return m, validateMount(m)
+}
+
+func validateMount(m state.Mount) error {
+	if m.Target == "" || !filepath.IsAbs(m.Target) {
+		return fmt.Errorf("mount target %q must be an absolute path", m.Target)
+	}
+	if filepath.Clean(m.Target) == "/" {
+		return fmt.Errorf("cannot mount over the container root")
+	}
+	if m.Type == state.MountTypeBind && m.Source == "" {
+		return fmt.Errorf("bind mount to %s needs a source", m.Target)
+	}
+	return nil
+}
+
+// ResolveMounts turns mount specs into host paths: bind sources are made
+// absolute and named volumes are created if they don't exist yet
+func ResolveMounts(mounts []state.Mount, createBindSource bool) ([]state.Mount, error) {
+	resolved := make([]state.Mount, 0, len(mounts))
+	for _, m := range mounts {
+		m.Target = filepath.Clean(m.Target)
+
+		switch m.Type {
+		case state.MountTypeBind:
+			abs, err := filepath.Abs(m.Source)
+			if err != nil {
+				return nil, err
+			}
+			m.Source = abs
+			if _, err := os.Stat(m.Source); os.IsNotExist(err) && createBindSource {
+				if err := os.MkdirAll(m.Source, 0o755); err != nil {
+					return nil, err
+				}
+			} else if os.IsNotExist(err) {
+				return nil, fmt.Errorf("bind source path does not exist: %s", m.Source)
+			} else if err != nil {
+				return nil, fmt.Errorf("invalid bind source path %s: %w", m.Source, err)
+			}
+		case state.MountTypeVolume:
+			v, err := volume.Create(m.Name)
+			if err != nil {
+				return nil, err
+			}
+			m.Name = v.Name
+			m.Source = v.Mountpoint
+		}
+
+		resolved = append(resolved, m)
+	}
+	return resolved, nil
+}
+
+// mountAll mounts the container's volumes under rootfs, parents before children
+func mountAll(rootfs string, mounts []state.Mount) error {
+	sorted := append([]state.Mount{}, mounts...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return strings.Count(sorted[i].Target, "/") < strings.Count(sorted[j].Target, "/")
+	})
+
+	for _, m := range sorted {
+		// symlinks in the image must not send the mount outside of the rootfs
+		target, err := secureJoin(rootfs, m.Target)
+		if err != nil {
+			return err
+		}
+
+		switch m.Type {
+		case state.MountTypeBind, state.MountTypeVolume:
+			if err := createMountpoint(m.Source, target); err != nil {
+				return err
+			}
+			if err := unix.Mount(m.Source, target, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+				return fmt.Errorf("failed to bind mount %s to %s: %w", m.Source, m.Target, err)
+			}
+			if m.ReadOnly {
+				// read only has to be applied by remounting the bind mount
+				if err := unix.Mount(m.Source, target, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY, ""); err != nil {
+					return fmt.Errorf("failed to make %s read only: %w", m.Target, err)
+				}
+			}
+		case state.MountTypeTmpfs:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+			var opts []string
+			if m.TmpfsSize > 0 {
+				opts = append(opts, fmt.Sprintf("size=%d", m.TmpfsSize))
+			}
+			if m.TmpfsMode != 0 {
+				opts = append(opts, fmt.Sprintf("mode=%o", m.TmpfsMode))
+			}
+			flags := uintptr(unix.MS_NOSUID | unix.MS_NODEV)
+			if m.ReadOnly {
+				flags |= unix.MS_RDONLY
+			}
+			if err := unix.Mount("tmpfs", target, "tmpfs", flags, strings.Join(opts, ",")); err != nil {
+				return fmt.Errorf("failed to mount tmpfs on %s: %w", m.Target, err)
+			}
+		}
+
+		logger.Log.Debug("mounted",
+			zap.String("type", m.Type),
+			zap.String("source", m.Source),
+			zap.String("target", m.Target),
+			zap.Bool("readOnly", m.ReadOnly))
+	}
+	return nil
+}
+
+// createMountpoint creates target as a dir or an empty file, matching source
+func createMountpoint(source, target string) error {
+	fi, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return os.MkdirAll(target, 0o755)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_RDONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// secureJoin joins path to root resolving symlinks as if root was "/"
+func secureJoin(root, path string) (string, error) {
+	resolved := "/"
+	parts := strings.Split(filepath.Clean("/"+path), "/")
+	links := 0
+
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		if part == "" || part == "." {
+			continue
+		}
+		if part == ".." {
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if err != nil {
+			if os.IsNotExist(err) {
+				// the rest will be created, nothing left to resolve
+				resolved = filepath.Join(append([]string{next}, parts...)...)
+				break
+			}
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > 255 {
+			return "", fmt.Errorf("too many symlinks resolving %s", path)
+		}
+		dest, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(dest) {
+			resolved = "/"
+		}
+		parts = append(strings.Split(dest, "/"), parts...)
+	}
+
+	return filepath.Join(root, filepath.Clean("/"+resolved)), nil
+}
@@ -0,0 +1,69 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/truongnhatanh7/xocker/internal/state"
+)
+
+func TestParseVolumeFlag(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    state.Mount
+		wantErr bool
+	}{
+		{"/data:/data", state.Mount{Type: state.MountTypeBind, Source: "/data", Target: "/data"}, false},
+		{"./conf:/etc/app:ro", state.Mount{Type: state.MountTypeBind, Source: "./conf", Target: "/etc/app", ReadOnly: true}, false},
+		{"pgdata:/var/lib/postgresql/data", state.Mount{Type: state.MountTypeVolume, Name: "pgdata", Target: "/var/lib/postgresql/data"}, false},
+		{"pgdata:/data:ro,rw", state.Mount{Type: state.MountTypeVolume, Name: "pgdata", Target: "/data"}, false},
+		{"/data", state.Mount{}, true},
+		{"/a:/b:ro:extra", state.Mount{}, true},
+		{"/data:/data:z", state.Mount{}, true},
+		{"/data:relative", state.Mount{}, true},
+		{"/data:/", state.Mount{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseVolumeFlag(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseVolumeFlag() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Fatalf("ParseVolumeFlag() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMountFlag(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    state.Mount
+		wantErr bool
+	}{
+		{"type=bind,source=/data,target=/data", state.Mount{Type: state.MountTypeBind, Source: "/data", Target: "/data"}, false},
+		{"type=bind,src=/data,dst=/data,readonly", state.Mount{Type: state.MountTypeBind, Source: "/data", Target: "/data", ReadOnly: true}, false},
+		{"type=bind,src=/data,dst=/data,readonly=false", state.Mount{Type: state.MountTypeBind, Source: "/data", Target: "/data"}, false},
+		{"source=cache,target=/cache", state.Mount{Type: state.MountTypeVolume, Name: "cache", Target: "/cache"}, false},
+		{"type=volume,target=/cache", state.Mount{Type: state.MountTypeVolume, Target: "/cache"}, false},
+		{"type=tmpfs,destination=/run,tmpfs-size=64m,tmpfs-mode=1777", state.Mount{Type: state.MountTypeTmpfs, Target: "/run", TmpfsSize: 64 << 20, TmpfsMode: 0o1777}, false},
+		{"type=tmpfs,source=x,target=/run", state.Mount{}, true},
+		{"type=bind,target=/data", state.Mount{}, true},
+		{"type=nfs,source=x,target=/data", state.Mount{}, true},
+		{"type=bind,source=/data,target=/data,readonly=maybe", state.Mount{}, true},
+		{"type=tmpfs,target=/run,tmpfs-mode=999", state.Mount{}, true},
+		{"type=tmpfs,target=/run,bogus=1", state.Mount{}, true},
+		{"type=volume,source=cache", state.Mount{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseMountFlag(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMountFlag() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Fatalf("ParseMountFlag() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
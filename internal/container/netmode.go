@@ -0,0 +1,133 @@
+package container
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"github.com/truongnhatanh7/xocker/internal/network"
+	"github.com/truongnhatanh7/xocker/internal/state"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// network modes besides attaching to a bridge network
+const (
+	NetworkModeNone      = "none"
+	NetworkModeHost      = "host"
+	NetworkModeContainer = "container"
+)
+
+// parseNetworkMode splits --network into the mode and the bridge network name
+// or, for container mode, the container reference
+func parseNetworkMode(value string) (mode string, target string) {
+	switch {
+	case value == NetworkModeNone || value == NetworkModeHost:
+		return value, ""
+	case strings.HasPrefix(value, NetworkModeContainer+":"):
+		return NetworkModeContainer, strings.TrimPrefix(value, NetworkModeContainer+":")
+	default:
+		return network.DriverBridge, value
+	}
+}
+
+// resolveNetworkMode validates --network and pins container mode to the full
+// ID of the target, it returns the bridge network for bridge mode only
+func (c *Container) resolveNetworkMode() (*network.Network, error) {
+	mode, target := parseNetworkMode(c.Network)
+
+	if mode != network.DriverBridge && len(c.Ports) > 0 {
+		return nil, fmt.Errorf("ports can't be published with --network %s", c.Network)
+	}
+	if mode != network.DriverBridge && len(c.NetworkAliases) > 0 {
+		return nil, fmt.Errorf("network aliases are only supported on bridge networks")
+	}
+	if mode != network.DriverBridge && !c.Shaping.IsZero() {
+		return nil, fmt.Errorf("traffic shaping is only supported on bridge networks")
+	}
+
+	switch mode {
+	case NetworkModeContainer:
+		st, err := state.Find(target)
+		if err != nil {
+			return nil, err
+		}
+		if st.ID == c.ID {
+			return nil, fmt.Errorf("a container can't join its own network namespace")
+		}
+		if st.Status != state.StatusRunning || !processAlive(st.Pid, st.PidStartTime) {
+			return nil, fmt.Errorf("container %s is not running", st.Name)
+		}
+		c.Network = NetworkModeContainer + ":" + st.ID
+		return nil, nil
+	case NetworkModeNone, NetworkModeHost:
+		return nil, nil
+	}
+
+	netw, err := resolveNetwork(target)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range c.Ports {
+		if strings.Contains(p.HostIP, ":") && !netw.HasIPv6() {
+			return nil, fmt.Errorf("can't publish %s, network %s has no IPv6 subnet", p, netw.Name)
+		}
+	}
+	c.Network = netw.Name
+	return netw, nil
+}
+
+func resolveNetwork(name string) (*network.Network, error) {
+	if name == "" || name == network.DefaultNetwork {
+		return network.EnsureDefault()
+	}
+	return network.Get(name)
+}
+
+// startEmbeddedDNS points the container's resolv.conf to the DNS server of the
+// network, unless it has its own --dns servers. Without a running server the
+// container falls back to the host's resolvers.
+func (c *Container) startEmbeddedDNS(netw *network.Network) {
+	if !netw.HasEmbeddedDNS() || len(c.DNS) > 0 {
+		return
+	}
+	if err := network.StartDNS(netw); err != nil {
+		logger.Log.Warn("embedded dns unavailable, using the host's resolvers", zap.String("network", netw.Name), zap.Error(err))
+		c.EmbeddedDNS = ""
+		return
+	}
+	c.EmbeddedDNS = netw.Gateway
+}
+
+// joinContainerNetwork moves the calling thread into the network namespace of
+// another container. Namespaces are per thread, the thread stays locked so the
+// final exec happens from it.
+func joinContainerNetwork(id string) error {
+	st, err := state.Load(id)
+	if err != nil {
+		return fmt.Errorf("failed to load container %s: %w", state.ShortID(id), err)
+	}
+	if st.Status != state.StatusRunning || st.Pid <= 0 {
+		return fmt.Errorf("container %s is not running", st.Name)
+	}
+
+	fd, err := unix.Open(fmt.Sprintf("/proc/%d/ns/net", st.Pid), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open network namespace of %s: %w", st.Name, err)
+	}
+	defer unix.Close(fd)
+	// checked once the namespace is open, the pid may have been reused. The
+	// host PID is not visible from our PID namespace, only from the host /proc.
+	if st.PidStartTime != 0 {
+		if started, err := processStartTime(st.Pid); err != nil || started != st.PidStartTime {
+			return fmt.Errorf("container %s is not running", st.Name)
+		}
+	}
+
+	runtime.LockOSThread()
+	if err := unix.Setns(fd, unix.CLONE_NEWNET); err != nil {
+		return fmt.Errorf("failed to join network namespace of %s: %w", st.Name, err)
+	}
+	return nil
+}
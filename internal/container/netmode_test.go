@@ -0,0 +1,52 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/truongnhatanh7/xocker/internal/network"
+	"github.com/truongnhatanh7/xocker/internal/state"
+)
+
+func TestParseNetworkMode(t *testing.T) {
+	tests := []struct {
+		value      string
+		wantMode   string
+		wantTarget string
+	}{
+		{"none", NetworkModeNone, ""},
+		{"host", NetworkModeHost, ""},
+		{"container:web", NetworkModeContainer, "web"},
+		{"container:", NetworkModeContainer, ""},
+		{"xocker", network.DriverBridge, "xocker"},
+		{"backend", network.DriverBridge, "backend"},
+		// only the exact keywords are modes
+		{"hostnet", network.DriverBridge, "hostnet"},
+		{"", network.DriverBridge, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			mode, target := parseNetworkMode(tt.value)
+			if mode != tt.wantMode || target != tt.wantTarget {
+				t.Fatalf("parseNetworkMode() = %q %q, want %q %q", mode, target, tt.wantMode, tt.wantTarget)
+			}
+		})
+	}
+}
+
+func TestResolveNetworkModeBridgeOnlyOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		c    Container
+	}{
+		{"ports", Container{Network: "none", Ports: []state.PortMapping{{HostPort: 80, ContainerPort: 80, Protocol: "tcp"}}}},
+		{"aliases", Container{Network: "host", NetworkAliases: []string{"db"}}},
+		{"shaping", Container{Network: "container:web", Shaping: state.LinkShaping{IngressRate: 1000}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.c.resolveNetworkMode(); err == nil {
+				t.Fatalf("resolveNetworkMode() accepted %s with --network %s", tt.name, tt.c.Network)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package container
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"github.com/truongnhatanh7/xocker/internal/state"
+	"go.uber.org/zap"
+)
+
+// Remove deletes a container's state dir, including its overlay upper dir.
+// A running container is only removed with force, it is killed first.
+func Remove(st *state.State, force bool) error {
+	if st.Status == state.StatusRunning && processAlive(st.Pid, st.PidStartTime) {
+		if !force {
+			return fmt.Errorf("container %s is running, stop it first or use --force", st.Name)
+		}
+		if err := Stop(st, 0); err != nil {
+			return err
+		}
+	}
+	// the supervisor died before recording the exit, release what it held
+	if st.Status != state.StatusExited {
+		logger.Log.Info("container exit was not recorded, cleaning up", zap.String("id", st.ID), zap.String("status", st.Status))
+		markExited(st, 128+int(syscall.SIGKILL))
+	}
+
+	if err := state.Remove(st.ID); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", state.Dir(st.ID), err)
+	}
+	logger.Log.Debug("removed container", zap.String("id", st.ID))
+	return nil
+}
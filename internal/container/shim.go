@@ -0,0 +1,88 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"github.com/truongnhatanh7/xocker/internal/state"
+	"github.com/truongnhatanh7/xocker/internal/sync"
+	"go.uber.org/zap"
+)
+
+// the shim reports back to the CLI on this fd once the container is running
+const shimReadyFd = 3
+
+func inShim() bool {
+	return os.Getenv("_XOCKER_SHIM") == "1"
+}
+
+// startShim re-executes xocker as a detached supervisor process which runs the
+// container in the foreground, owns its stdio and cleans up once it exits.
+// It returns as soon as the shim reports the container is running.
+func startShim(container *Container) error {
+	dir := state.Dir(container.ID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create state dir %s: %w", dir, err)
+	}
+
+	shimLogPath := filepath.Join(dir, "shim.log")
+	shimLog, err := os.OpenFile(shimLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open shim log: %w", err)
+	}
+	defer shimLog.Close()
+
+	parentConn, shimConn, err := sync.CreateSocketPair()
+	if err != nil {
+		return err
+	}
+	defer parentConn.Close()
+
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	// like the child, the shim loads the saved config by _CONTAINER_ID
+	c := exec.Command(self, "run")
+	c.Stdin = nil
+	c.Stdout = shimLog
+	c.Stderr = shimLog
+	c.ExtraFiles = []*os.File{shimConn}
+	// own session, so the shim survives the terminal that started it
+	c.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	c.Env = append(os.Environ(), "_XOCKER_SHIM=1", "_CONTAINER_ID="+container.ID)
+
+	logger.Log.Debug("starting shim", zap.String("c", c.String()))
+	if err := c.Start(); err != nil {
+		shimConn.Close()
+		return fmt.Errorf("failed to start shim: %w", err)
+	}
+	shimConn.Close()
+
+	if _, err := sync.WaitForReady(parentConn, 30*time.Second); err != nil {
+		return fmt.Errorf("container failed to start, see %s: %w", shimLogPath, err)
+	}
+	logger.Log.Debug("shim reported container running", zap.Int("shimPid", c.Process.Pid))
+
+	return c.Process.Release()
+}
+
+// signalShimReady tells the CLI waiting in startShim that the container is up
+func signalShimReady(id string) {
+	conn := os.NewFile(uintptr(shimReadyFd), "shim-ready")
+	if conn == nil {
+		logger.Log.Warn("shim ready fd is not available")
+		return
+	}
+	defer conn.Close()
+
+	if err := sync.SignalReady(conn, id); err != nil {
+		logger.Log.Warn("failed to signal shim ready", zap.Error(err))
+	}
+}
@@ -0,0 +1,20 @@
+package container
+
+import (
+	"fmt"
+
+	"github.com/truongnhatanh7/xocker/internal/cgroupv2"
+	"github.com/truongnhatanh7/xocker/internal/state"
+)
+
+// Stats reads the resource usage of a running container from its cgroup
+func Stats(st *state.State) (*cgroupv2.Stats, error) {
+	if st.Status != state.StatusRunning || !processAlive(st.Pid, st.PidStartTime) {
+		return nil, fmt.Errorf("container %s is not running", st.Name)
+	}
+	path, err := processCgroupPath(st.Pid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find cgroup of %s: %w", st.Name, err)
+	}
+	return cgroupv2.ReadStats(path)
+}
@@ -0,0 +1,194 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"github.com/truongnhatanh7/xocker/internal/state"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// how long stop waits for the supervisor to record the exit before doing it itself
+	supervisorGracePeriod = 5 * time.Second
+	// SIGKILL can't be ignored, this only covers a process stuck in the kernel
+	killTimeout = 10 * time.Second
+)
+
+// ParseSignal accepts signal names with or without the SIG prefix and numbers
+func ParseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 || n > 64 {
+			return 0, fmt.Errorf("invalid signal number %d", n)
+		}
+		return syscall.Signal(n), nil
+	}
+
+	name := strings.ToUpper(s)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig := unix.SignalNum(name)
+	if sig == 0 {
+		return 0, fmt.Errorf("unknown signal %q", s)
+	}
+	return sig, nil
+}
+
+// Kill sends sig to the container's init process
+func Kill(st *state.State, sig syscall.Signal) error {
+	if st.Status != state.StatusRunning || !processAlive(st.Pid, st.PidStartTime) {
+		return fmt.Errorf("container %s is not running", st.Name)
+	}
+
+	logger.Log.Debug("sending signal", zap.String("id", st.ID), zap.Int("pid", st.Pid), zap.String("signal", sig.String()))
+	if err := signalProcess(st.Pid, st.PidStartTime, sig); err != nil {
+		if err == unix.ESRCH {
+			return fmt.Errorf("container %s is not running", st.Name)
+		}
+		return fmt.Errorf("failed to send %s to %d: %w", sig, st.Pid, err)
+	}
+	return nil
+}
+
+// Stop sends SIGTERM, waits up to timeout for the container to exit and then
+// sends SIGKILL. It returns once the container exit has been recorded and its
+// resources were released.
+func Stop(st *state.State, timeout time.Duration) error {
+	if st.Status != state.StatusRunning {
+		return nil
+	}
+
+	// exit code recorded if the supervisor is no longer around to observe it
+	code := 128 + int(syscall.SIGTERM)
+	if processAlive(st.Pid, st.PidStartTime) {
+		if err := signalProcess(st.Pid, st.PidStartTime, syscall.SIGTERM); err != nil && err != unix.ESRCH {
+			return fmt.Errorf("failed to send SIGTERM to %d: %w", st.Pid, err)
+		}
+
+		if !waitForExit(st.Pid, st.PidStartTime, timeout) {
+			logger.Log.Info("container did not stop in time, killing it",
+				zap.String("id", st.ID), zap.Duration("timeout", timeout))
+			if err := signalProcess(st.Pid, st.PidStartTime, syscall.SIGKILL); err != nil && err != unix.ESRCH {
+				return fmt.Errorf("failed to send SIGKILL to %d: %w", st.Pid, err)
+			}
+			code = 128 + int(syscall.SIGKILL)
+			if !waitForExit(st.Pid, st.PidStartTime, killTimeout) {
+				return fmt.Errorf("container %s did not exit after SIGKILL", st.Name)
+			}
+		}
+	}
+
+	return reconcileExited(st, code)
+}
+
+// reconcileExited waits for the supervisor to record the exit. If the
+// supervisor is gone (crashed, terminal closed) the exit is recorded here.
+func reconcileExited(st *state.State, code int) error {
+	deadline := time.Now().Add(supervisorGracePeriod)
+	for processAlive(st.SupervisorPid, st.SupervisorStartTime) && time.Now().Before(deadline) {
+		latest, err := state.Load(st.ID)
+		if err != nil {
+			return err
+		}
+		if latest.Status == state.StatusExited {
+			*st = *latest
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	latest, err := state.Load(st.ID)
+	if err != nil {
+		return err
+	}
+	*st = *latest
+	if st.Status == state.StatusExited {
+		return nil
+	}
+
+	logger.Log.Info("supervisor is gone, cleaning up container", zap.String("id", st.ID))
+	markExited(st, code)
+	return nil
+}
+
+func waitForExit(pid int, startTime uint64, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for processAlive(pid, startTime) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
+
+// processAlive reports whether pid is still the process started at startTime.
+// A zero startTime, from states saved before it was recorded, only checks pid.
+func processAlive(pid int, startTime uint64) bool {
+	if pid <= 0 {
+		return false
+	}
+	if err := unix.Kill(pid, 0); err != nil && err != unix.EPERM {
+		return false
+	}
+	if startTime == 0 {
+		return true
+	}
+	got, err := processStartTime(pid)
+	return err == nil && got == startTime
+}
+
+// signalProcess sends sig to pid unless it was reused since startTime, it
+// returns ESRCH then. The pidfd pins the process between the check and the
+// signal.
+func signalProcess(pid int, startTime uint64, sig syscall.Signal) error {
+	fd, err := unix.PidfdOpen(pid, 0)
+	if err == unix.ENOSYS {
+		// before linux 5.3
+		if !processAlive(pid, startTime) {
+			return unix.ESRCH
+		}
+		return unix.Kill(pid, sig)
+	}
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	if !processAlive(pid, startTime) {
+		return unix.ESRCH
+	}
+	return unix.PidfdSendSignal(fd, sig, nil, 0)
+}
+
+// processStartTime reads the start time of pid from /proc/<pid>/stat
+func processStartTime(pid int) (uint64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	return parseStartTime(string(data))
+}
+
+// parseStartTime returns field 22 of a /proc/<pid>/stat line. The command
+// name of field 2 may contain spaces and parentheses, the fields are counted
+// from its last closing parenthesis.
+func parseStartTime(stat string) (uint64, error) {
+	i := strings.LastIndexByte(stat, ')')
+	if i < 0 {
+		return 0, fmt.Errorf("invalid stat line %q", stat)
+	}
+	// the fields after the command name start at field 3
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 22-2 {
+		return 0, fmt.Errorf("invalid stat line %q", stat)
+	}
+	return strconv.ParseUint(fields[22-3], 10, 64)
+}
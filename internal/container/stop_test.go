@@ -0,0 +1,99 @@
+package container
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    syscall.Signal
+		wantErr bool
+	}{
+		{"KILL", syscall.SIGKILL, false},
+		{"SIGTERM", syscall.SIGTERM, false},
+		{"hup", syscall.SIGHUP, false},
+		{"sigusr1", syscall.SIGUSR1, false},
+		{"15", syscall.SIGTERM, false},
+		{"64", syscall.Signal(64), false},
+		{"0", 0, true},
+		{"65", 0, true},
+		{"-9", 0, true},
+		{"NOPE", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseSignal(tt.in)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Fatalf("ParseSignal() = %v %v, want %v error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseStartTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		stat    string
+		want    uint64
+		wantErr bool
+	}{
+		{"plain", "1 (init) S 0 1 1 0 -1 4194560 1 2 3 4 5 6 7 8 20 0 1 0 42 1000 10", 42, false},
+		{"spaces and parens in the name", "7 (a) b (c)) S 0 1 1 0 -1 4194560 1 2 3 4 5 6 7 8 20 0 1 0 99 1000 10", 99, false},
+		{"truncated", "1 (init) S 0 1", 0, true},
+		{"no name", "garbage", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseStartTime(tt.stat)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Fatalf("parseStartTime() = %d %v, want %d error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProcessAliveChecksStartTime(t *testing.T) {
+	start, err := processStartTime(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !processAlive(os.Getpid(), start) || !processAlive(os.Getpid(), 0) {
+		t.Fatal("own process not alive")
+	}
+	if processAlive(os.Getpid(), start+1) {
+		t.Fatal("a reused pid is alive")
+	}
+}
+
+func TestSignalProcessSkipsReusedPid(t *testing.T) {
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	start, err := processStartTime(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := signalProcess(cmd.Process.Pid, start+1, syscall.SIGKILL); err != unix.ESRCH {
+		t.Fatalf("signalProcess() = %v, want ESRCH for another start time", err)
+	}
+	if !processAlive(cmd.Process.Pid, start) {
+		t.Fatal("the process was signaled")
+	}
+
+	if err := signalProcess(cmd.Process.Pid, start, syscall.SIGKILL); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); exitCode(err) != 128+int(syscall.SIGKILL) {
+		t.Fatalf("Wait() = %v, want killed", err)
+	}
+}
@@ -0,0 +1,245 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// read from the root of the container, tests point them elsewhere
+var (
+	passwdPath = "/etc/passwd"
+	groupPath  = "/etc/group"
+)
+
+// execUser is the identity the container process runs as
+type execUser struct {
+	Uid    uint32
+	Gid    uint32
+	Groups []uint32
+	Home   string
+}
+
+type passwdEntry struct {
+	name string
+	uid  uint32
+	gid  uint32
+	home string
+}
+
+type groupEntry struct {
+	name    string
+	gid     uint32
+	members []string
+}
+
+// MergeEnv overrides the KEY=VALUE entries of base with overrides. An override
+// without '=' takes its value from the current environment, or is dropped if unset.
+func MergeEnv(base, overrides []string) ([]string, error) {
+	env := append([]string{}, base...)
+	index := map[string]int{}
+	for i, kv := range env {
+		key, _, _ := strings.Cut(kv, "=")
+		index[key] = i
+	}
+
+	for _, kv := range overrides {
+		key, _, hasValue := strings.Cut(kv, "=")
+		if key == "" {
+			return nil, fmt.Errorf("invalid environment variable %q", kv)
+		}
+		if !hasValue {
+			value, ok := os.LookupEnv(key)
+			if !ok {
+				continue
+			}
+			kv = key + "=" + value
+		}
+
+		if i, ok := index[key]; ok {
+			env[i] = kv
+			continue
+		}
+		index[key] = len(env)
+		env = append(env, kv)
+	}
+	return env, nil
+}
+
+// withDefaultEnv fills in PATH and HOME when the image and flags did not set them
+func withDefaultEnv(env []string, home string) []string {
+	has := func(key string) bool {
+		for _, kv := range env {
+			if strings.HasPrefix(kv, key+"=") {
+				return true
+			}
+		}
+		return false
+	}
+
+	env = append([]string{}, env...)
+	if !has("PATH") {
+		env = append(env, defaultPath)
+	}
+	if !has("HOME") {
+		env = append(env, "HOME="+home)
+	}
+	return env
+}
+
+// lookupUser resolves a <name|uid>[:<group|gid>] spec against the /etc/passwd
+// and /etc/group of the current root, empty means root
+func lookupUser(spec string) (*execUser, error) {
+	userPart, groupPart, hasGroup := strings.Cut(spec, ":")
+	if userPart == "" {
+		userPart = "0"
+	}
+
+	passwd, err := readPasswd(passwdPath)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := readGroup(groupPath)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &execUser{Home: "/"}
+	var name string
+	if uid, err := strconv.ParseUint(userPart, 10, 32); err == nil {
+		u.Uid = uint32(uid)
+		for _, p := range passwd {
+			if p.uid == u.Uid {
+				name, u.Gid, u.Home = p.name, p.gid, p.home
+				break
+			}
+		}
+	} else {
+		found := false
+		for _, p := range passwd {
+			if p.name == userPart {
+				name, u.Uid, u.Gid, u.Home = p.name, p.uid, p.gid, p.home
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unable to find user %s: no matching entries in passwd file", userPart)
+		}
+	}
+
+	if hasGroup {
+		if gid, err := strconv.ParseUint(groupPart, 10, 32); err == nil {
+			u.Gid = uint32(gid)
+		} else {
+			found := false
+			for _, g := range groups {
+				if g.name == groupPart {
+					u.Gid = g.gid
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unable to find group %s: no matching entries in group file", groupPart)
+			}
+		}
+	}
+
+	if name != "" {
+		for _, g := range groups {
+			for _, m := range g.members {
+				if m == name && g.gid != u.Gid {
+					u.Groups = append(u.Groups, g.gid)
+				}
+			}
+		}
+	}
+	return u, nil
+}
+
+func readPasswd(path string) ([]passwdEntry, error) {
+	var entries []passwdEntry
+	err := readColonFile(path, func(fields []string) {
+		if len(fields) < 6 {
+			return
+		}
+		uid, err1 := strconv.ParseUint(fields[2], 10, 32)
+		gid, err2 := strconv.ParseUint(fields[3], 10, 32)
+		if err1 != nil || err2 != nil {
+			return
+		}
+		entries = append(entries, passwdEntry{fields[0], uint32(uid), uint32(gid), fields[5]})
+	})
+	return entries, err
+}
+
+func readGroup(path string) ([]groupEntry, error) {
+	var entries []groupEntry
+	err := readColonFile(path, func(fields []string) {
+		if len(fields) < 4 {
+			return
+		}
+		gid, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return
+		}
+		var members []string
+		if fields[3] != "" {
+			members = strings.Split(fields[3], ",")
+		}
+		entries = append(entries, groupEntry{fields[0], uint32(gid), members})
+	})
+	return entries, err
+}
+
+// readColonFile calls fn for every non comment line, a missing file is empty
+func readColonFile(path string, fn func(fields []string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fn(strings.Split(line, ":"))
+	}
+	return scanner.Err()
+}
+
+// lookPath searches the PATH of env, exec.LookPath would use our own PATH
+func lookPath(file string, env []string) (string, error) {
+	if strings.Contains(file, "/") {
+		return file, nil
+	}
+
+	path := ""
+	for _, kv := range env {
+		if v, ok := strings.CutPrefix(kv, "PATH="); ok {
+			path = v
+		}
+	}
+
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		candidate := filepath.Join(dir, file)
+		fi, err := os.Stat(candidate)
+		if err == nil && fi.Mode().IsRegular() && fi.Mode().Perm()&0o111 != 0 {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("executable file %q not found in $PATH", file)
+}
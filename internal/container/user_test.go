@@ -0,0 +1,84 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMergeEnv(t *testing.T) {
+	t.Setenv("XOCKER_TEST_HOST", "from-host")
+	os.Unsetenv("XOCKER_TEST_UNSET")
+
+	tests := []struct {
+		name      string
+		base      []string
+		overrides []string
+		want      []string
+		wantErr   bool
+	}{
+		{"no overrides", []string{"A=1"}, nil, []string{"A=1"}, false},
+		{"override keeps the position", []string{"A=1", "B=2"}, []string{"A=3"}, []string{"A=3", "B=2"}, false},
+		{"new keys are appended", []string{"A=1"}, []string{"C=3", "C=4"}, []string{"A=1", "C=4"}, false},
+		{"empty value", []string{"A=1"}, []string{"A="}, []string{"A="}, false},
+		{"value from the host", nil, []string{"XOCKER_TEST_HOST"}, []string{"XOCKER_TEST_HOST=from-host"}, false},
+		{"unset on the host is dropped", []string{"A=1"}, []string{"XOCKER_TEST_UNSET"}, []string{"A=1"}, false},
+		{"no key", nil, []string{"=1"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergeEnv(tt.base, tt.overrides)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MergeEnv() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("MergeEnv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupUser(t *testing.T) {
+	dir := t.TempDir()
+	oldPasswd, oldGroup := passwdPath, groupPath
+	passwdPath, groupPath = filepath.Join(dir, "passwd"), filepath.Join(dir, "group")
+	t.Cleanup(func() {
+		passwdPath, groupPath = oldPasswd, oldGroup
+	})
+	passwd := "root:x:0:0:root:/root:/bin/sh\n# comment\nnginx:x:101:101:nginx:/var/cache/nginx:/sbin/nologin\n"
+	group := "root:x:0:\nnginx:x:101:\nwww:x:33:nginx\nadm:x:4:root,nginx\n"
+	if err := os.WriteFile(passwdPath, []byte(passwd), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(groupPath, []byte(group), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		spec    string
+		want    execUser
+		wantErr bool
+	}{
+		{"", execUser{Uid: 0, Gid: 0, Groups: []uint32{4}, Home: "/root"}, false},
+		{"nginx", execUser{Uid: 101, Gid: 101, Groups: []uint32{33, 4}, Home: "/var/cache/nginx"}, false},
+		{"101", execUser{Uid: 101, Gid: 101, Groups: []uint32{33, 4}, Home: "/var/cache/nginx"}, false},
+		{"nginx:www", execUser{Uid: 101, Gid: 33, Groups: []uint32{4}, Home: "/var/cache/nginx"}, false},
+		{"nginx:500", execUser{Uid: 101, Gid: 500, Groups: []uint32{33, 4}, Home: "/var/cache/nginx"}, false},
+		// unknown uids are allowed, like in docker
+		{"1000", execUser{Uid: 1000, Gid: 0, Home: "/"}, false},
+		{"nobody", execUser{}, true},
+		{"nginx:nogroup", execUser{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := lookupUser(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lookupUser() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(*got, tt.want) {
+				t.Fatalf("lookupUser() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// just enough of RFC 1035 to answer A, AAAA and PTR questions
+
+const (
+	TypeA    uint16 = 1
+	TypePTR  uint16 = 12
+	TypeAAAA uint16 = 28
+
+	ClassINET uint16 = 1
+
+	RcodeSuccess  = 0
+	RcodeFormErr  = 1
+	RcodeServFail = 2
+	RcodeNXDomain = 3
+
+	headerLen = 12
+	flagQR    = 1 << 15
+	flagAA    = 1 << 10
+	flagRD    = 1 << 8
+	flagRA    = 1 << 7
+)
+
+var errMalformed = errors.New("malformed dns message")
+
+type Question struct {
+	Name  string
+	Type  uint16
+	Class uint16
+}
+
+// Query is a parsed request, only the first question is kept
+type Query struct {
+	ID       uint16
+	Flags    uint16
+	Question Question
+	// raw question section, echoed in the answer
+	rawQuestion []byte
+}
+
+type Answer struct {
+	Type uint16
+	TTL  uint32
+	Data []byte
+}
+
+func ParseQuery(msg []byte) (*Query, error) {
+	if len(msg) < headerLen {
+		return nil, errMalformed
+	}
+	q := &Query{
+		ID:    binary.BigEndian.Uint16(msg[0:2]),
+		Flags: binary.BigEndian.Uint16(msg[2:4]),
+	}
+	if q.Flags&flagQR != 0 {
+		return nil, fmt.Errorf("%w: not a query", errMalformed)
+	}
+	if binary.BigEndian.Uint16(msg[4:6]) == 0 {
+		return nil, fmt.Errorf("%w: no question", errMalformed)
+	}
+
+	name, off, err := readName(msg, headerLen)
+	if err != nil {
+		return nil, err
+	}
+	if off+4 > len(msg) {
+		return nil, errMalformed
+	}
+	q.Question = Question{
+		Name:  name,
+		Type:  binary.BigEndian.Uint16(msg[off : off+2]),
+		Class: binary.BigEndian.Uint16(msg[off+2 : off+4]),
+	}
+	q.rawQuestion = msg[headerLen : off+4]
+	return q, nil
+}
+
+// readName decodes a possibly compressed name starting at off, it returns the
+// lowercased name without the trailing dot and the offset after it
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errMalformed
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.ToLower(strings.Join(labels, ".")), end, nil
+		case l&0xC0 == 0xC0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return "", 0, errMalformed
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:off+2]) & 0x3FFF)
+			jumps++
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, errMalformed
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
+
+func appendName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// Response builds the answer to q, answers all refer to the question name
+func (q *Query) Response(rcode int, answers []Answer) []byte {
+	flags := uint16(flagQR|flagAA|flagRA) | q.Flags&flagRD | q.Flags&0x7800 | uint16(rcode)
+
+	b := make([]byte, headerLen, 512)
+	binary.BigEndian.PutUint16(b[0:2], q.ID)
+	binary.BigEndian.PutUint16(b[2:4], flags)
+	binary.BigEndian.PutUint16(b[4:6], 1)
+	binary.BigEndian.PutUint16(b[6:8], uint16(len(answers)))
+	b = append(b, q.rawQuestion...)
+
+	for _, a := range answers {
+		// pointer to the question name right after the header
+		b = append(b, 0xC0, headerLen)
+		b = binary.BigEndian.AppendUint16(b, a.Type)
+		b = binary.BigEndian.AppendUint16(b, ClassINET)
+		b = binary.BigEndian.AppendUint32(b, a.TTL)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(a.Data)))
+		b = append(b, a.Data...)
+	}
+	return b
+}
+
+// ErrorResponse answers a message that couldn't be parsed as a query
+func ErrorResponse(msg []byte, rcode int) []byte {
+	if len(msg) < 2 {
+		return nil
+	}
+	b := make([]byte, headerLen)
+	copy(b[0:2], msg[0:2])
+	binary.BigEndian.PutUint16(b[2:4], flagQR|flagRA|uint16(rcode))
+	return b
+}
+
+// AddrAnswer is an A or AAAA record for addr
+func AddrAnswer(addr netip.Addr, ttl uint32) Answer {
+	typ := TypeA
+	if addr.Is6() && !addr.Is4In6() {
+		typ = TypeAAAA
+	}
+	return Answer{Type: typ, TTL: ttl, Data: addr.Unmap().AsSlice()}
+}
+
+// PTRAnswer is a PTR record pointing to name
+func PTRAnswer(name string, ttl uint32) Answer {
+	return Answer{Type: TypePTR, TTL: ttl, Data: appendName(nil, name)}
+}
+
+// ParseReverseName turns a in-addr.arpa or ip6.arpa name back into the address
+func ParseReverseName(name string) (netip.Addr, bool) {
+	if v4, ok := strings.CutSuffix(name, ".in-addr.arpa"); ok {
+		parts := strings.Split(v4, ".")
+		if len(parts) != 4 {
+			return netip.Addr{}, false
+		}
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+		// a part like ::1 would turn the joined parts into an IPv6 address
+		addr, err := netip.ParseAddr(strings.Join(parts, "."))
+		return addr, err == nil && addr.Is4()
+	}
+
+	if v6, ok := strings.CutSuffix(name, ".ip6.arpa"); ok {
+		nibbles := strings.Split(v6, ".")
+		if len(nibbles) != 32 {
+			return netip.Addr{}, false
+		}
+		var hex strings.Builder
+		for i := len(nibbles) - 1; i >= 0; i-- {
+			if len(nibbles[i]) != 1 || !strings.Contains("0123456789abcdef", nibbles[i]) {
+				return netip.Addr{}, false
+			}
+			hex.WriteString(nibbles[i])
+			if i%4 == 0 && i > 0 {
+				hex.WriteByte(':')
+			}
+		}
+		addr, err := netip.ParseAddr(hex.String())
+		return addr, err == nil
+	}
+	return netip.Addr{}, false
+}
@@ -0,0 +1,239 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net/netip"
+	"testing"
+)
+
+// query builds a query message for name, which is written as is so tests can
+// pass raw and compressed names
+func query(id uint16, flags uint16, qdcount uint16, rawName []byte, qtype uint16) []byte {
+	b := make([]byte, headerLen)
+	binary.BigEndian.PutUint16(b[0:2], id)
+	binary.BigEndian.PutUint16(b[2:4], flags)
+	binary.BigEndian.PutUint16(b[4:6], qdcount)
+	b = append(b, rawName...)
+	b = binary.BigEndian.AppendUint16(b, qtype)
+	return binary.BigEndian.AppendUint16(b, ClassINET)
+}
+
+func TestParseQuery(t *testing.T) {
+	web := appendName(nil, "web.local")
+	valid := query(0xBEEF, flagRD, 1, web, TypeA)
+
+	tests := []struct {
+		name     string
+		msg      []byte
+		wantName string
+		wantType uint16
+		wantErr  bool
+	}{
+		{name: "a query", msg: valid, wantName: "web.local", wantType: TypeA},
+		{name: "lowercased", msg: query(1, 0, 1, appendName(nil, "WeB.LoCaL"), TypeAAAA), wantName: "web.local", wantType: TypeAAAA},
+		{name: "root name", msg: query(1, 0, 1, []byte{0}, TypeA), wantName: "", wantType: TypeA},
+		{name: "unknown qtype", msg: query(1, 0, 1, web, 65280), wantName: "web.local", wantType: 65280},
+		{name: "trailing bytes", msg: append(append([]byte{}, valid...), 1, 2, 3), wantName: "web.local", wantType: TypeA},
+		{name: "empty", msg: nil, wantErr: true},
+		{name: "truncated header", msg: valid[:headerLen-1], wantErr: true},
+		{name: "header only", msg: valid[:headerLen], wantErr: true},
+		{name: "truncated label", msg: valid[:headerLen+2], wantErr: true},
+		{name: "missing terminator", msg: valid[:headerLen+len(web)-1], wantErr: true},
+		{name: "missing type and class", msg: valid[:headerLen+len(web)], wantErr: true},
+		{name: "truncated class", msg: valid[:len(valid)-1], wantErr: true},
+		{name: "label longer than message", msg: query(1, 0, 1, []byte{63, 'a', 0}, TypeA)[:headerLen+3], wantErr: true},
+		{name: "response", msg: query(1, flagQR, 1, web, TypeA), wantErr: true},
+		{name: "no question", msg: query(1, 0, 0, web, TypeA), wantErr: true},
+		{name: "pointer to itself", msg: query(1, 0, 1, []byte{0xC0, headerLen}, TypeA), wantErr: true},
+		{name: "pointer loop", msg: query(1, 0, 1, []byte{3, 'w', 'e', 'b', 0xC0, headerLen}, TypeA), wantErr: true},
+		{name: "pointer past the end", msg: query(1, 0, 1, []byte{0xC0, 0xFF}, TypeA), wantErr: true},
+		{name: "truncated pointer", msg: append(valid[:headerLen:headerLen], 0xC0), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := ParseQuery(tt.msg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseQuery() = %+v, want an error", q.Question)
+				}
+				if !errors.Is(err, errMalformed) {
+					t.Fatalf("ParseQuery() = %v, want errMalformed", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q.Question.Name != tt.wantName || q.Question.Type != tt.wantType || q.Question.Class != ClassINET {
+				t.Fatalf("ParseQuery() = %+v, want %q type %d", q.Question, tt.wantName, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestParseQueryCompressedName(t *testing.T) {
+	// "web" followed by a pointer to the "local" name of the question
+	msg := query(1, 0, 1, []byte{5, 'l', 'o', 'c', 'a', 'l', 0}, TypeA)
+	msg = append(msg, 3, 'w', 'e', 'b', 0xC0, headerLen)
+
+	name, off, err := readName(msg, len(msg)-6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "web.local" || off != len(msg) {
+		t.Fatalf("readName() = %q %d, want web.local %d", name, off, len(msg))
+	}
+}
+
+// every parse of garbage must return, never panic
+func TestParseQueryMalformedNoPanic(t *testing.T) {
+	valid := query(7, flagRD, 1, appendName(nil, "web.local"), TypePTR)
+	for i := 0; i <= len(valid); i++ {
+		ParseQuery(valid[:i])
+		ErrorResponse(valid[:i], RcodeFormErr)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		msg := make([]byte, rng.Intn(64))
+		rng.Read(msg)
+		if len(msg) > 5 {
+			// mostly queries with a question, so the name parsing is reached
+			msg[2] &^= 0x80
+			msg[5] |= 1
+		}
+		if q, err := ParseQuery(msg); err == nil {
+			q.Response(RcodeSuccess, []Answer{AddrAnswer(netip.MustParseAddr("10.0.0.2"), recordTTL)})
+		}
+	}
+}
+
+func FuzzParseQuery(f *testing.F) {
+	f.Add(query(1, flagRD, 1, appendName(nil, "web.local"), TypeA))
+	f.Add(query(1, 0, 1, []byte{0xC0, headerLen}, TypeA))
+	f.Add([]byte{0, 1})
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		if q, err := ParseQuery(msg); err == nil {
+			q.Response(RcodeNXDomain, nil)
+		}
+	})
+}
+
+func TestResponse(t *testing.T) {
+	msg := query(0xBEEF, flagRD, 1, appendName(nil, "web.local"), TypeA)
+	q, err := ParseQuery(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := q.Response(RcodeSuccess, []Answer{AddrAnswer(netip.MustParseAddr("10.0.0.2"), 10)})
+	if id := binary.BigEndian.Uint16(resp[0:2]); id != 0xBEEF {
+		t.Fatalf("id = %#x, want 0xbeef", id)
+	}
+	flags := binary.BigEndian.Uint16(resp[2:4])
+	if flags&flagQR == 0 || flags&flagRD == 0 || flags&0xF != RcodeSuccess {
+		t.Fatalf("flags = %#x, want a response echoing RD", flags)
+	}
+	if qd, an := binary.BigEndian.Uint16(resp[4:6]), binary.BigEndian.Uint16(resp[6:8]); qd != 1 || an != 1 {
+		t.Fatalf("qdcount %d ancount %d, want 1 1", qd, an)
+	}
+	// the question is echoed, the answer points back at its name
+	question := msg[headerLen:]
+	if !bytes.Equal(resp[headerLen:headerLen+len(question)], question) {
+		t.Fatal("question not echoed")
+	}
+	answer := resp[headerLen+len(question):]
+	want := []byte{0xC0, headerLen, 0, byte(TypeA), 0, byte(ClassINET), 0, 0, 0, 10, 0, 4, 10, 0, 0, 2}
+	if !bytes.Equal(answer, want) {
+		t.Fatalf("answer = %v, want %v", answer, want)
+	}
+
+	// the response parses back as the same name
+	name, _, err := readName(resp, headerLen+len(question))
+	if err != nil || name != "web.local" {
+		t.Fatalf("answer name = %q %v, want web.local", name, err)
+	}
+
+	nx := q.Response(RcodeNXDomain, nil)
+	if rcode := binary.BigEndian.Uint16(nx[2:4]) & 0xF; rcode != RcodeNXDomain {
+		t.Fatalf("rcode = %d, want %d", rcode, RcodeNXDomain)
+	}
+	if an := binary.BigEndian.Uint16(nx[6:8]); an != 0 {
+		t.Fatalf("ancount = %d, want 0", an)
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	if resp := ErrorResponse([]byte{1}, RcodeFormErr); resp != nil {
+		t.Fatalf("ErrorResponse() = %v, want nil without an id", resp)
+	}
+	resp := ErrorResponse([]byte{0xAB, 0xCD, 0xFF}, RcodeFormErr)
+	if len(resp) != headerLen || resp[0] != 0xAB || resp[1] != 0xCD {
+		t.Fatalf("ErrorResponse() = %v, want a header with the id", resp)
+	}
+	if flags := binary.BigEndian.Uint16(resp[2:4]); flags&flagQR == 0 || flags&0xF != RcodeFormErr {
+		t.Fatalf("flags = %#x, want a FORMERR response", flags)
+	}
+}
+
+func TestAddrAnswer(t *testing.T) {
+	tests := []struct {
+		addr     string
+		wantType uint16
+		wantLen  int
+	}{
+		{"10.0.0.2", TypeA, 4},
+		{"::ffff:10.0.0.2", TypeA, 4},
+		{"fd00::2", TypeAAAA, 16},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			a := AddrAnswer(netip.MustParseAddr(tt.addr), recordTTL)
+			if a.Type != tt.wantType || len(a.Data) != tt.wantLen {
+				t.Fatalf("AddrAnswer() = type %d len %d, want %d %d", a.Type, len(a.Data), tt.wantType, tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestParseReverseName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string // empty when invalid
+	}{
+		{"2.0.18.172.in-addr.arpa", "172.18.0.2"},
+		{"0.18.172.in-addr.arpa", ""},
+		{"1.2.0.18.172.in-addr.arpa", ""},
+		{"256.0.18.172.in-addr.arpa", ""},
+		{"02.0.18.172.in-addr.arpa", ""},
+		{"x.0.18.172.in-addr.arpa", ""},
+		{"4.3.2.::1.in-addr.arpa", ""},
+		{"..in-addr.arpa", ""},
+		{"in-addr.arpa", ""},
+		{"2.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa", "fd00::2"},
+		{"2.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.ip6.arpa", ""},
+		{"g.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa", ""},
+		{"22.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa", ""},
+		{":.:.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa", ""},
+		{"...............................ip6.arpa", ""},
+		{"web.local", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, ok := ParseReverseName(tt.name)
+			if tt.want == "" {
+				if ok {
+					t.Fatalf("ParseReverseName() = %s, want invalid", addr)
+				}
+				return
+			}
+			if !ok || addr != netip.MustParseAddr(tt.want) {
+				t.Fatalf("ParseReverseName() = %s %v, want %s", addr, ok, tt.want)
+			}
+		})
+	}
+}
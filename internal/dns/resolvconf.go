@@ -0,0 +1,92 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+const (
+	hostResolvConf = "/etc/resolv.conf"
+	// the real upstreams when the host runs systemd-resolved on 127.0.0.53
+	systemdResolvConf = "/run/systemd/resolve/resolv.conf"
+)
+
+// used when the host only has loopback resolvers, unreachable from a container
+var defaultNameservers = []string{"8.8.8.8", "8.8.4.4"}
+
+type ResolvConf struct {
+	Nameservers []string
+	Search      []string
+	Options     []string
+}
+
+func ParseResolvConf(data []byte) ResolvConf {
+	var conf ResolvConf
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], ";") {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			conf.Nameservers = append(conf.Nameservers, fields[1])
+		case "search", "domain":
+			conf.Search = fields[1:]
+		case "options":
+			conf.Options = append(conf.Options, fields[1:]...)
+		}
+	}
+	return conf
+}
+
+// HostResolvConf returns the host's resolver config, with keepLoopback false
+// loopback nameservers are replaced since they can't be reached from another
+// network namespace
+func HostResolvConf(keepLoopback bool) ResolvConf {
+	data, _ := os.ReadFile(hostResolvConf)
+	conf := ParseResolvConf(data)
+	if keepLoopback {
+		return conf
+	}
+
+	nameservers := nonLoopback(conf.Nameservers)
+	if len(nameservers) == 0 {
+		if data, err := os.ReadFile(systemdResolvConf); err == nil {
+			nameservers = nonLoopback(ParseResolvConf(data).Nameservers)
+		}
+	}
+	if len(nameservers) == 0 {
+		nameservers = defaultNameservers
+	}
+	conf.Nameservers = nameservers
+	return conf
+}
+
+func nonLoopback(nameservers []string) []string {
+	var out []string
+	for _, ns := range nameservers {
+		if addr, err := netip.ParseAddr(ns); err == nil && !addr.IsLoopback() {
+			out = append(out, ns)
+		}
+	}
+	return out
+}
+
+func (c ResolvConf) String() string {
+	var b strings.Builder
+	b.WriteString("# generated by xocker\n")
+	for _, ns := range c.Nameservers {
+		b.WriteString("nameserver " + ns + "\n")
+	}
+	if len(c.Search) > 0 {
+		b.WriteString("search " + strings.Join(c.Search, " ") + "\n")
+	}
+	if len(c.Options) > 0 {
+		b.WriteString("options " + strings.Join(c.Options, " ") + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,163 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// answers about containers change when they come and go, keep it short
+	recordTTL       = 10
+	upstreamTimeout = 2 * time.Second
+	maxMessageSize  = 4096
+)
+
+// Records resolves the local names served by a Server
+type Records interface {
+	// LookupName returns the addresses of a container name or alias
+	LookupName(name string) []netip.Addr
+	// LookupAddr returns the names of the container owning addr
+	LookupAddr(addr netip.Addr) []string
+}
+
+// Server answers for the containers of one network and forwards the rest to
+// the upstream resolvers
+type Server struct {
+	Addr      netip.AddrPort
+	Records   Records
+	Upstreams []string
+
+	conn *net.UDPConn
+}
+
+// Listen binds the server address, separate from Serve so callers know when
+// the server is reachable
+func (s *Server) Listen() error {
+	conn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(s.Addr))
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.Addr, err)
+	}
+	s.conn = conn
+	return nil
+}
+
+func (s *Server) Serve() error {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, client, err := s.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		msg := append([]byte(nil), buf[:n]...)
+		go s.handle(msg, client)
+	}
+}
+
+func (s *Server) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+func (s *Server) handle(msg []byte, client netip.AddrPort) {
+	resp := s.answer(msg)
+	if resp == nil {
+		return
+	}
+	if _, err := s.conn.WriteToUDPAddrPort(resp, client); err != nil {
+		logger.Log.Debug("failed to send dns answer", zap.String("client", client.String()), zap.Error(err))
+	}
+}
+
+func (s *Server) answer(msg []byte) []byte {
+	q, err := ParseQuery(msg)
+	if err != nil {
+		return ErrorResponse(msg, RcodeFormErr)
+	}
+	name := q.Question.Name
+	logger.Log.Debug("dns query", zap.String("name", name), zap.Uint16("type", q.Question.Type))
+
+	if q.Question.Class == ClassINET {
+		switch q.Question.Type {
+		case TypeA, TypeAAAA:
+			if addrs := s.Records.LookupName(name); len(addrs) > 0 {
+				var answers []Answer
+				for _, a := range addrs {
+					if (q.Question.Type == TypeA) == a.Is4() {
+						answers = append(answers, AddrAnswer(a, recordTTL))
+					}
+				}
+				// the name exists, an empty answer tells the client there is no such record type
+				return q.Response(RcodeSuccess, answers)
+			}
+		case TypePTR:
+			if addr, ok := ParseReverseName(name); ok {
+				if names := s.Records.LookupAddr(addr); len(names) > 0 {
+					var answers []Answer
+					for _, n := range names {
+						answers = append(answers, PTRAnswer(n, recordTTL))
+					}
+					return q.Response(RcodeSuccess, answers)
+				}
+			}
+		}
+	}
+
+	resp, err := s.forward(msg)
+	if err != nil {
+		logger.Log.Debug("dns forward failed", zap.String("name", name), zap.Error(err))
+		return q.Response(RcodeServFail, nil)
+	}
+	return resp
+}
+
+// forward relays the query unchanged to the first upstream that answers
+func (s *Server) forward(msg []byte) ([]byte, error) {
+	if len(s.Upstreams) == 0 {
+		return nil, fmt.Errorf("no upstream resolvers")
+	}
+
+	var lastErr error
+	for _, upstream := range s.Upstreams {
+		resp, err := exchange(upstream, msg)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func exchange(upstream string, msg []byte) ([]byte, error) {
+	conn, err := net.Dial("udp", net.JoinHostPort(upstream, "53"))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(upstreamTimeout))
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// ignore stray datagrams not answering this query
+		if n >= 2 && buf[0] == msg[0] && buf[1] == msg[1] {
+			return buf[:n], nil
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package dns
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"go.uber.org/zap"
+)
+
+type fakeRecords map[string][]netip.Addr
+
+func (r fakeRecords) LookupName(name string) []netip.Addr {
+	return r[name]
+}
+
+func (r fakeRecords) LookupAddr(addr netip.Addr) []string {
+	var names []string
+	for name, addrs := range r {
+		for _, a := range addrs {
+			if a == addr {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+func TestServerAnswer(t *testing.T) {
+	logger.Log = zap.NewNop()
+	s := &Server{Records: fakeRecords{
+		"web": {netip.MustParseAddr("172.18.0.2"), netip.MustParseAddr("fd00::2")},
+	}}
+
+	tests := []struct {
+		name      string
+		msg       []byte
+		wantRcode uint16
+		wantCount uint16
+	}{
+		{"a record", query(1, 0, 1, appendName(nil, "web"), TypeA), RcodeSuccess, 1},
+		{"aaaa record", query(1, 0, 1, appendName(nil, "web"), TypeAAAA), RcodeSuccess, 1},
+		{"ptr record", query(1, 0, 1, appendName(nil, "2.0.18.172.in-addr.arpa"), TypePTR), RcodeSuccess, 1},
+		// no upstream to forward to
+		{"unknown name", query(1, 0, 1, appendName(nil, "db"), TypeA), RcodeServFail, 0},
+		{"unknown qtype", query(1, 0, 1, appendName(nil, "web"), 65280), RcodeServFail, 0},
+		{"malformed", []byte{0, 1, 0, 0, 0, 1}, RcodeFormErr, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := s.answer(tt.msg)
+			if len(resp) < headerLen {
+				t.Fatalf("answer() = %v, want a message", resp)
+			}
+			if rcode := binary.BigEndian.Uint16(resp[2:4]) & 0xF; rcode != tt.wantRcode {
+				t.Fatalf("rcode = %d, want %d", rcode, tt.wantRcode)
+			}
+			if an := binary.BigEndian.Uint16(resp[6:8]); an != tt.wantCount {
+				t.Fatalf("ancount = %d, want %d", an, tt.wantCount)
+			}
+		})
+	}
+
+	if resp := s.answer([]byte{1}); resp != nil {
+		t.Fatalf("answer() = %v, want no answer without an id", resp)
+	}
+}
@@ -0,0 +1,422 @@
+package image
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Load imports the images of an OCI image layout directory or of a tarball
+// produced by `docker save` (either the legacy format or an OCI layout).
+// tag, when set, is added to every loaded image.
+func Load(path, tag string) ([]*Image, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	root := path
+	if !fi.IsDir() {
+		if err := os.MkdirAll(tmpDir, 0o700); err != nil {
+			return nil, err
+		}
+		root, err = os.MkdirTemp(tmpDir, "load-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(root)
+
+		if err := extractArchive(path, root); err != nil {
+			return nil, fmt.Errorf("failed to extract %s: %w", path, err)
+		}
+	}
+
+	var images []*Image
+	if _, err := os.Stat(filepath.Join(root, "manifest.json")); err == nil {
+		images, err = loadDockerArchive(root)
+		if err != nil {
+			return nil, err
+		}
+	} else if _, err := os.Stat(filepath.Join(root, "index.json")); err == nil {
+		images, err = loadOCILayout(root)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, fmt.Errorf("%s is neither an OCI image layout nor a docker save archive", path)
+	}
+
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no image for %s/%s found in %s", runtime.GOOS, runtime.GOARCH, path)
+	}
+
+	for _, img := range images {
+		if tag != "" && !contains(img.RepoTags, NormalizeRef(tag)) {
+			img.RepoTags = append(img.RepoTags, NormalizeRef(tag))
+		}
+		if err := untag(img.RepoTags, img.ID); err != nil {
+			return nil, err
+		}
+		if err := img.save(); err != nil {
+			return nil, fmt.Errorf("failed to save image %s: %w", img.ID, err)
+		}
+		logger.Log.Info("loaded image", zap.String("id", img.ID), zap.Strings("tags", img.RepoTags))
+	}
+	return images, nil
+}
+
+func loadDockerArchive(root string) ([]*Image, error) {
+	var manifests []dockerManifest
+	if err := readJSON(filepath.Join(root, "manifest.json"), &manifests); err != nil {
+		return nil, err
+	}
+
+	var images []*Image
+	for _, m := range manifests {
+		configPath := filepath.Join(root, filepath.Clean("/"+m.Config))
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image config: %w", err)
+		}
+		id := digestOf(data)
+
+		// a docker save archive holds a single platform per image, unlike an index
+		var config ociConfig
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("failed to parse image config %s: %w", id, err)
+		}
+		if !(&platform{Architecture: config.Architecture, OS: config.OS}).matches() {
+			return nil, fmt.Errorf("image %s is for %s/%s, expected %s/%s", id, config.OS, config.Architecture, runtime.GOOS, runtime.GOARCH)
+		}
+
+		// config is stored as <hex>.json (legacy) or blobs/sha256/<hex>
+		if name := strings.TrimSuffix(filepath.Base(m.Config), ".json"); isHexDigest(name) && "sha256:"+name != id {
+			return nil, fmt.Errorf("config digest mismatch: expected sha256:%s, got %s", name, id)
+		}
+
+		var tags []string
+		for _, t := range m.RepoTags {
+			tags = append(tags, familiarName(t))
+		}
+
+		layers := make([]string, len(m.Layers))
+		for i, l := range m.Layers {
+			layers[i] = filepath.Join(root, filepath.Clean("/"+l))
+			if name := filepath.Base(l); filepath.Base(filepath.Dir(l)) == "sha256" && isHexDigest(name) {
+				if err := verifyBlob(layers[i], "sha256:"+name); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		img, err := importImage(id, data, layers, tags)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+func loadOCILayout(root string) ([]*Image, error) {
+	var index ociIndex
+	if err := readJSON(filepath.Join(root, "index.json"), &index); err != nil {
+		return nil, err
+	}
+
+	var images []*Image
+	for _, desc := range index.Manifests {
+		if !desc.Platform.matches() {
+			continue
+		}
+
+		var tags []string
+		if name := desc.Annotations[annotationImageName]; name != "" {
+			tags = append(tags, familiarName(name))
+		} else if name := desc.Annotations[annotationRefName]; strings.ContainsAny(name, ":/") {
+			// a bare ref name is only a tag, without a repository it can't be used as a name
+			tags = append(tags, familiarName(name))
+		}
+
+		manifest, err := resolveManifest(root, desc)
+		if err != nil {
+			return nil, err
+		}
+		if manifest == nil {
+			continue
+		}
+
+		configData, err := readBlob(root, manifest.Config)
+		if err != nil {
+			return nil, err
+		}
+
+		layers := make([]string, len(manifest.Layers))
+		for i, l := range manifest.Layers {
+			path, err := blobPath(root, l.Digest)
+			if err != nil {
+				return nil, err
+			}
+			if err := verifyBlob(path, l.Digest); err != nil {
+				return nil, err
+			}
+			layers[i] = path
+		}
+
+		img, err := importImage(manifest.Config.Digest, configData, layers, tags)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// resolveManifest follows (nested) indexes down to the manifest for the
+// current platform, it returns nil if there is none
+func resolveManifest(root string, desc descriptor) (*ociManifest, error) {
+	data, err := readBlob(root, desc)
+	if err != nil {
+		return nil, err
+	}
+
+	switch desc.MediaType {
+	case mediaTypeOCIManifest, mediaTypeDockerSchema2:
+		var m ociManifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("failed to parse manifest %s: %w", desc.Digest, err)
+		}
+		return &m, nil
+	case mediaTypeOCIIndex, mediaTypeDockerList:
+		var index ociIndex
+		if err := json.Unmarshal(data, &index); err != nil {
+			return nil, fmt.Errorf("failed to parse index %s: %w", desc.Digest, err)
+		}
+		for _, child := range index.Manifests {
+			// attestation manifests use the unknown platform
+			if !child.Platform.matches() {
+				continue
+			}
+			return resolveManifest(root, child)
+		}
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported media type %q for %s", desc.MediaType, desc.Digest)
+	}
+}
+
+// matches reports whether p is the current platform, descriptors without a
+// platform match any
+func (p *platform) matches() bool {
+	if p == nil || p.OS == "" && p.Architecture == "" {
+		return true
+	}
+	return p.OS == runtime.GOOS && p.Architecture == runtime.GOARCH
+}
+
+// importImage unpacks the layers (paths to layer tarballs, base first) of the
+// image whose config blob is configData
+func importImage(id string, configData []byte, layers []string, tags []string) (*Image, error) {
+	var config ociConfig
+	if err := json.Unmarshal(configData, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse image config %s: %w", id, err)
+	}
+
+	if len(config.RootFS.DiffIDs) != len(layers) {
+		return nil, fmt.Errorf("image %s has %d layers but %d diff ids", id, len(layers), len(config.RootFS.DiffIDs))
+	}
+
+	img := &Image{
+		ID:           id,
+		RepoTags:     tags,
+		Architecture: config.Architecture,
+		OS:           config.OS,
+		Loaded:       time.Now(),
+		Config:       config.Config,
+		Layers:       config.RootFS.DiffIDs,
+	}
+	if config.Created != nil {
+		img.Created = *config.Created
+	}
+
+	for i, path := range layers {
+		size, err := unpackLayer(path, config.RootFS.DiffIDs[i])
+		if err != nil {
+			return nil, err
+		}
+		img.Size += size
+	}
+
+	// keep the tags of a previous load of the same image
+	if prev, err := Get(id); err == nil && prev.ID == id {
+		for _, t := range prev.RepoTags {
+			if !contains(img.RepoTags, t) {
+				img.RepoTags = append(img.RepoTags, t)
+			}
+		}
+	}
+	return img, nil
+}
+
+func blobPath(root, digest string) (string, error) {
+	alg, hexPart, ok := strings.Cut(digest, ":")
+	if !ok || alg != "sha256" || !isHexDigest(hexPart) {
+		return "", fmt.Errorf("unsupported digest %q", digest)
+	}
+	return filepath.Join(root, "blobs", alg, hexPart), nil
+}
+
+// readBlob reads a small blob (index, manifest, config) and checks its digest
+func readBlob(root string, desc descriptor) ([]byte, error) {
+	path, err := blobPath(root, desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", desc.Digest, err)
+	}
+	if got := digestOf(data); got != desc.Digest {
+		return nil, fmt.Errorf("blob digest mismatch: expected %s, got %s", desc.Digest, got)
+	}
+	return data, nil
+}
+
+func verifyBlob(path, digest string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if got := "sha256:" + hex.EncodeToString(h.Sum(nil)); got != digest {
+		return fmt.Errorf("blob digest mismatch: expected %s, got %s", digest, got)
+	}
+	return nil
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func isHexDigest(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// familiarName shortens docker hub references, docker.io/library/alpine:3 -> alpine:3
+func familiarName(ref string) string {
+	ref = strings.TrimPrefix(ref, "docker.io/")
+	ref = strings.TrimPrefix(ref, "library/")
+	return NormalizeRef(ref)
+}
+
+func readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// extractArchive unpacks the regular files of an image archive into dest
+func extractArchive(path, dest string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := decompress(f)
+	if err != nil {
+		return err
+	}
+
+	// docker save links duplicate layers to the first copy, which may come later in the archive
+	links := map[string]string{}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dest, filepath.Clean("/"+hdr.Name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			out.Close()
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			link := filepath.Join(filepath.Dir(hdr.Name), hdr.Linkname)
+			links[target] = filepath.Join(dest, filepath.Clean("/"+link))
+		}
+	}
+
+	for target, source := range links {
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := copyFile(source, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
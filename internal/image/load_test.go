@@ -0,0 +1,69 @@
+package image
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeJSON(t *testing.T, path string, v any) {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadDockerArchiveRejectsOtherPlatform(t *testing.T) {
+	root := t.TempDir()
+	writeJSON(t, filepath.Join(root, "config.json"), ociConfig{Architecture: "xarch", OS: "linux"})
+	writeJSON(t, filepath.Join(root, "manifest.json"), []dockerManifest{{Config: "config.json"}})
+
+	_, err := loadDockerArchive(root)
+	if err == nil || !strings.Contains(err.Error(), "linux/xarch") {
+		t.Fatalf("expected a platform mismatch error, got %v", err)
+	}
+}
+
+func TestLoadOCILayoutSkipsOtherPlatforms(t *testing.T) {
+	root := t.TempDir()
+	// the blob doesn't exist, reading it would fail the load
+	writeJSON(t, filepath.Join(root, "index.json"), ociIndex{Manifests: []descriptor{{
+		MediaType: mediaTypeOCIManifest,
+		Digest:    "sha256:" + strings.Repeat("0", 64),
+		Platform:  &platform{Architecture: "xarch", OS: "linux"},
+	}}})
+
+	images, err := loadOCILayout(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 0 {
+		t.Fatalf("expected no image, got %d", len(images))
+	}
+}
+
+func TestPlatformMatches(t *testing.T) {
+	tests := []struct {
+		name string
+		p    *platform
+		want bool
+	}{
+		{"no platform", nil, true},
+		{"empty platform", &platform{}, true},
+		{"unknown platform", &platform{Architecture: "unknown", OS: "unknown"}, false},
+		{"other arch", &platform{Architecture: "xarch", OS: "linux"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.matches(); got != tt.want {
+				t.Fatalf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
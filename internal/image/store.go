@@ -0,0 +1,191 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/truongnhatanh7/xocker/internal/state"
+)
+
+var (
+	ImagesDir = filepath.Join(state.RootDir, "images")
+	LayersDir = filepath.Join(state.RootDir, "layers", "sha256")
+	// scratch space for imports, on the same filesystem as the layer store so renames are atomic
+	tmpDir = filepath.Join(state.RootDir, "tmp")
+)
+
+const imageFile = "image.json"
+
+type Image struct {
+	// ID is the digest of the image config blob
+	ID           string      `json:"id"`
+	RepoTags     []string    `json:"repoTags"`
+	Architecture string      `json:"architecture"`
+	OS           string      `json:"os"`
+	Created      time.Time   `json:"created"`
+	Loaded       time.Time   `json:"loaded"`
+	Config       ImageConfig `json:"config"`
+	// Layers are the uncompressed layer digests (diff IDs), base layer first
+	Layers []string `json:"layers"`
+	Size   int64    `json:"size"`
+}
+
+func (img *Image) ShortID() string {
+	return state.ShortID(strings.TrimPrefix(img.ID, "sha256:"))
+}
+
+// LowerDirs returns the unpacked layers in overlay lowerdir order, top layer first
+func (img *Image) LowerDirs() []string {
+	dirs := make([]string, 0, len(img.Layers))
+	for i := len(img.Layers) - 1; i >= 0; i-- {
+		dirs = append(dirs, LayerDir(img.Layers[i]))
+	}
+	return dirs
+}
+
+// Dir is where per-image data is kept
+func (img *Image) Dir() string {
+	return Dir(img.ID)
+}
+
+func Dir(id string) string {
+	return filepath.Join(ImagesDir, strings.TrimPrefix(id, "sha256:"))
+}
+
+func LayerDir(diffID string) string {
+	return filepath.Join(LayersDir, strings.TrimPrefix(diffID, "sha256:"))
+}
+
+func (img *Image) save() error {
+	if err := os.MkdirAll(img.Dir(), 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(img, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(img.Dir(), imageFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(img.Dir(), imageFile))
+}
+
+// List returns all imported images, most recently loaded first
+func List() ([]*Image, error) {
+	entries, err := os.ReadDir(ImagesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var images []*Image
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(ImagesDir, e.Name(), imageFile))
+		if err != nil {
+			continue
+		}
+		var img Image
+		if err := json.Unmarshal(data, &img); err != nil {
+			return nil, fmt.Errorf("failed to parse image %s: %w", e.Name(), err)
+		}
+		images = append(images, &img)
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Loaded.After(images[j].Loaded)
+	})
+	return images, nil
+}
+
+// Get resolves an image by reference (name[:tag]), full ID or unique ID prefix
+func Get(ref string) (*Image, error) {
+	images, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	normalized := NormalizeRef(ref)
+	for _, img := range images {
+		for _, tag := range img.RepoTags {
+			if tag == normalized {
+				return img, nil
+			}
+		}
+	}
+
+	prefix := strings.TrimPrefix(ref, "sha256:")
+	var matches []*Image
+	for _, img := range images {
+		if strings.HasPrefix(strings.TrimPrefix(img.ID, "sha256:"), prefix) {
+			matches = append(matches, img)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no such image: %s", ref)
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("multiple images match prefix %s", ref)
+	}
+}
+
+// NormalizeRef adds the implicit latest tag
+func NormalizeRef(ref string) string {
+	// a colon after the last slash is a tag, before it a registry port
+	if strings.LastIndex(ref, ":") > strings.LastIndex(ref, "/") || strings.Contains(ref, "@") {
+		return ref
+	}
+	return ref + ":latest"
+}
+
+// untag moves tags from older images onto the newly loaded one
+func untag(tags []string, except string) error {
+	images, err := List()
+	if err != nil {
+		return err
+	}
+
+	for _, img := range images {
+		if img.ID == except {
+			continue
+		}
+
+		var kept []string
+		for _, t := range img.RepoTags {
+			if !contains(tags, t) {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) != len(img.RepoTags) {
+			img.RepoTags = kept
+			if err := img.save(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,298 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// unpackLayer extracts the (possibly gzipped) layer tarball at path into the
+// layer store, verifying its uncompressed digest against diffID. Layers that
+// are already present are not unpacked again.
+func unpackLayer(path, diffID string) (int64, error) {
+	dest := LayerDir(diffID)
+	if _, err := os.Stat(dest); err == nil {
+		logger.Log.Debug("layer already exists", zap.String("diffID", diffID))
+		return dirSize(dest)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r, err := decompress(f)
+	if err != nil {
+		return 0, fmt.Errorf("layer %s: %w", diffID, err)
+	}
+
+	if err := os.MkdirAll(tmpDir, 0o700); err != nil {
+		return 0, err
+	}
+	tmp, err := os.MkdirTemp(tmpDir, "layer-")
+	if err != nil {
+		return 0, err
+	}
+	// os.MkdirTemp creates 0700, the layer root becomes the container's /
+	if err := os.Chmod(tmp, 0o755); err != nil {
+		os.RemoveAll(tmp)
+		return 0, err
+	}
+
+	h := sha256.New()
+	tee := io.TeeReader(r, h)
+	size, err := extract(tar.NewReader(tee), tmp)
+	if err == nil {
+		// the digest covers the tar padding after the last entry too
+		_, err = io.Copy(io.Discard, tee)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return 0, fmt.Errorf("failed to unpack layer %s: %w", diffID, err)
+	}
+
+	if got := "sha256:" + hex.EncodeToString(h.Sum(nil)); got != diffID {
+		os.RemoveAll(tmp)
+		return 0, fmt.Errorf("layer digest mismatch: expected %s, got %s", diffID, got)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0o700); err != nil {
+		os.RemoveAll(tmp)
+		return 0, err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.RemoveAll(tmp)
+		return 0, err
+	}
+
+	logger.Log.Info("unpacked layer", zap.String("diffID", diffID), zap.Int64("size", size))
+	return size, nil
+}
+
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		return nil, fmt.Errorf("zstd compressed layers are not supported")
+	default:
+		return br, nil
+	}
+}
+
+// extract writes the entries of a layer tarball under root, converting OCI
+// whiteouts into their overlayfs equivalents
+func extract(tr *tar.Reader, root string) (int64, error) {
+	var size int64
+	dirTimes := map[string]time.Time{}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		rel := filepath.Clean("/" + hdr.Name)
+		if rel == "/" {
+			continue
+		}
+		dir, base := filepath.Split(rel)
+		parent := filepath.Join(root, dir)
+		if err := checkNoSymlinks(root, dir); err != nil {
+			return 0, err
+		}
+		if err := os.MkdirAll(parent, 0o755); err != nil {
+			return 0, err
+		}
+
+		// opaque dir: hide everything the lower layers have in it
+		if base == whiteoutOpaque {
+			if err := unix.Lsetxattr(parent, "trusted.overlay.opaque", []byte("y"), 0); err != nil {
+				return 0, fmt.Errorf("failed to mark %s opaque: %w", dir, err)
+			}
+			continue
+		}
+		// deleted file: overlay whiteout is a 0/0 char device
+		if strings.HasPrefix(base, whiteoutPrefix) {
+			target := filepath.Join(parent, strings.TrimPrefix(base, whiteoutPrefix))
+			if err := unix.Mknod(target, unix.S_IFCHR, 0); err != nil {
+				return 0, fmt.Errorf("failed to create whiteout %s: %w", target, err)
+			}
+			continue
+		}
+
+		path := filepath.Join(root, rel)
+		// a later entry replaces an earlier one, except a dir over a dir
+		if fi, err := os.Lstat(path); err == nil && !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(path); err != nil {
+				return 0, err
+			}
+		}
+
+		mode := hdr.FileInfo().Mode()
+		isSymlink := hdr.Typeflag == tar.TypeSymlink
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.Mkdir(path, 0o755); err != nil && !os.IsExist(err) {
+				return 0, err
+			}
+		case tar.TypeReg:
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+			if err != nil {
+				return 0, err
+			}
+			n, err := io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return 0, err
+			}
+			size += n
+		case tar.TypeSymlink:
+			if err := os.Symlink(hdr.Linkname, path); err != nil {
+				return 0, err
+			}
+		case tar.TypeLink:
+			// the target must be reached without following a symlink, or the
+			// link, chown and chmod below would apply to a host file
+			linkRel := filepath.Clean("/" + hdr.Linkname)
+			if err := checkNoSymlinks(root, filepath.Dir(linkRel)); err != nil {
+				return 0, fmt.Errorf("hardlink %s to %s: %w", rel, linkRel, err)
+			}
+			target := filepath.Join(root, linkRel)
+			if fi, err := os.Lstat(target); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+				// link(2) doesn't follow it, but chmod would
+				isSymlink = true
+			}
+			if err := os.Link(target, path); err != nil {
+				return 0, err
+			}
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			devMode := uint32(unix.S_IFIFO)
+			if hdr.Typeflag == tar.TypeChar {
+				devMode = unix.S_IFCHR
+			} else if hdr.Typeflag == tar.TypeBlock {
+				devMode = unix.S_IFBLK
+			}
+			dev := int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor)))
+			if err := unix.Mknod(path, devMode|uint32(mode.Perm()), dev); err != nil {
+				return 0, err
+			}
+		default:
+			logger.Log.Debug("skipping unsupported tar entry",
+				zap.String("name", hdr.Name), zap.Uint8("type", hdr.Typeflag))
+			continue
+		}
+
+		if err := os.Lchown(path, hdr.Uid, hdr.Gid); err != nil {
+			return 0, err
+		}
+		for key, value := range hdr.PAXRecords {
+			if name, ok := strings.CutPrefix(key, "SCHILY.xattr."); ok {
+				if err := unix.Lsetxattr(path, name, []byte(value), 0); err != nil {
+					logger.Log.Debug("failed to set xattr", zap.String("path", rel), zap.String("xattr", name), zap.Error(err))
+				}
+			}
+		}
+
+		if isSymlink {
+			if err := lutimes(path, hdr.ModTime); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		// after chown, which clears setuid bits
+		if err := os.Chmod(path, mode); err != nil {
+			return 0, err
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			// creating entries inside would bump it, set it last
+			dirTimes[path] = hdr.ModTime
+			continue
+		}
+		if err := lutimes(path, hdr.ModTime); err != nil {
+			return 0, err
+		}
+	}
+
+	for path, t := range dirTimes {
+		if err := lutimes(path, t); err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+// checkNoSymlinks refuses entries that would be written through a symlink
+// created by an earlier entry, which could point outside of root
+func checkNoSymlinks(root, dir string) error {
+	cur := root
+	for _, part := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		cur = filepath.Join(cur, part)
+		fi, err := os.Lstat(cur)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("layer entry under %s traverses a symlink", dir)
+		}
+	}
+	return nil
+}
+
+func lutimes(path string, t time.Time) error {
+	ts := unix.NsecToTimespec(t.UnixNano())
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+func dirSize(root string) (int64, error) {
+	var size int64
+	err := filepath.Walk(root, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
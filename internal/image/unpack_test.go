@@ -0,0 +1,120 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"go.uber.org/zap"
+)
+
+func init() {
+	logger.Log = zap.NewNop()
+}
+
+// buildTar writes hdrs into a tar stream, regular entries get body as content
+func buildTar(t *testing.T, hdrs []*tar.Header, body string) *tar.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range hdrs {
+		hdr.Uid, hdr.Gid = os.Getuid(), os.Getgid()
+		hdr.ModTime = time.Unix(0, 0)
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(body))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return tar.NewReader(&buf)
+}
+
+func TestExtractRefusesHardlinkThroughSymlink(t *testing.T) {
+	host := t.TempDir()
+	secret := filepath.Join(host, "shadow")
+	if err := os.WriteFile(secret, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+
+	tr := buildTar(t, []*tar.Header{
+		{Name: "x", Typeflag: tar.TypeSymlink, Linkname: host, Mode: 0o777},
+		{Name: "y", Typeflag: tar.TypeLink, Linkname: "x/shadow", Mode: 0o777},
+	}, "")
+	if _, err := extract(tr, root); err == nil {
+		t.Fatal("expected a hardlink through a symlink to be refused")
+	}
+
+	if _, err := os.Lstat(filepath.Join(root, "y")); !os.IsNotExist(err) {
+		t.Fatalf("hardlink was created: %v", err)
+	}
+	fi, err := os.Stat(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Fatalf("host file mode changed to %v", fi.Mode().Perm())
+	}
+}
+
+func TestExtractHardlinkToSymlinkKeepsTargetMode(t *testing.T) {
+	host := t.TempDir()
+	secret := filepath.Join(host, "shadow")
+	if err := os.WriteFile(secret, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+
+	tr := buildTar(t, []*tar.Header{
+		{Name: "x", Typeflag: tar.TypeSymlink, Linkname: secret, Mode: 0o777},
+		{Name: "y", Typeflag: tar.TypeLink, Linkname: "x", Mode: 0o777},
+	}, "")
+	if _, err := extract(tr, root); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Fatalf("host file mode changed to %v", fi.Mode().Perm())
+	}
+}
+
+func TestExtractHardlink(t *testing.T) {
+	root := t.TempDir()
+	tr := buildTar(t, []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "etc/a", Typeflag: tar.TypeReg, Mode: 0o644},
+		{Name: "etc/b", Typeflag: tar.TypeLink, Linkname: "etc/a", Mode: 0o644},
+	}, "hello")
+	if _, err := extract(tr, root); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := os.Stat(filepath.Join(root, "etc/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.Stat(filepath.Join(root, "etc/b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(a, b) {
+		t.Fatal("etc/b is not a hardlink of etc/a")
+	}
+}
@@ -0,0 +1,173 @@
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// lines longer than this are split into several entries, like docker does
+const maxLineSize = 16 * 1024
+
+// Entry is one line of container output, the on-disk format is JSON lines
+type Entry struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+// JSONFile writes container output as JSON lines and rotates the file once it
+// grows over maxSize, keeping at most maxFile files (path, path.1, ...).
+type JSONFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	maxFile int
+	f       *os.File
+	size    int64
+}
+
+// NewJSONFile opens the log at path for appending. maxSize <= 0 disables rotation.
+func NewJSONFile(path string, maxSize int64, maxFile int) (*JSONFile, error) {
+	if maxFile < 1 {
+		maxFile = 1
+	}
+
+	l := &JSONFile{
+		path:    path,
+		maxSize: maxSize,
+		maxFile: maxFile,
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *JSONFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open log file %s: %w", l.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	l.f = f
+	l.size = info.Size()
+	return nil
+}
+
+func (l *JSONFile) write(e *Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(b)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+	return err
+}
+
+// rotate shifts path.N-1 -> path.N ... path -> path.1 and starts a new file
+func (l *JSONFile) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+
+	if l.maxFile == 1 {
+		if err := os.Truncate(l.path, 0); err != nil {
+			return err
+		}
+		return l.open()
+	}
+
+	for i := l.maxFile - 1; i > 0; i-- {
+		from := rotatedName(l.path, i-1)
+		if err := os.Rename(from, rotatedName(l.path, i)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate %s: %w", from, err)
+		}
+	}
+	return l.open()
+}
+
+func rotatedName(path string, i int) string {
+	if i == 0 {
+		return path
+	}
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+// Writer returns a writer that records everything written to it as entries of stream
+func (l *JSONFile) Writer(stream string) io.WriteCloser {
+	return &streamWriter{log: l, stream: stream}
+}
+
+func (l *JSONFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// streamWriter splits a byte stream into lines, holding back an unterminated tail
+type streamWriter struct {
+	log    *JSONFile
+	stream string
+	buf    []byte
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	for {
+		i := indexNewline(w.buf)
+		if i < 0 || i >= maxLineSize {
+			// no complete line yet, or one too long even if it is
+			if len(w.buf) < maxLineSize {
+				break
+			}
+			i = maxLineSize - 1
+		}
+
+		line := string(w.buf[:i+1])
+		w.buf = w.buf[i+1:]
+		if err := w.log.write(&Entry{Log: line, Stream: w.stream, Time: time.Now().UTC()}); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close flushes a trailing partial line
+func (w *streamWriter) Close() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := string(w.buf)
+	w.buf = nil
+	return w.log.write(&Entry{Log: line, Stream: w.stream, Time: time.Now().UTC()})
+}
+
+func indexNewline(b []byte) int {
+	for i, c := range b {
+		if c == '\n' {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,205 @@
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// entrySize is the on-disk size of the lines written by writeLines
+var entrySize = func() int64 {
+	b, _ := json.Marshal(&Entry{Log: "line 00\n", Stream: "stdout", Time: time.Unix(0, 0).UTC()})
+	return int64(len(b)) + 1
+}()
+
+// writeLines writes n lines "line NN" timestamped one second apart from start
+func writeLines(t *testing.T, l *JSONFile, first, n int, start time.Time) {
+	t.Helper()
+	for i := first; i < first+n; i++ {
+		e := &Entry{Log: fmt.Sprintf("line %02d\n", i), Stream: "stdout", Time: start.Add(time.Duration(i) * time.Second).UTC()}
+		if err := l.write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readAll(t *testing.T, path string, opts ReadOptions) []string {
+	t.Helper()
+	var lines []string
+	err := Read(path, opts, func(e *Entry) error {
+		lines = append(lines, strings.TrimSuffix(e.Log, "\n"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+func lineRange(first, last int) []string {
+	var lines []string
+	for i := first; i <= last; i++ {
+		lines = append(lines, fmt.Sprintf("line %02d", i))
+	}
+	return lines
+}
+
+func equal(a, b []string) bool {
+	return strings.Join(a, ",") == strings.Join(b, ",")
+}
+
+func TestJSONFileRotation(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxFile int
+		// files expected after writing 10 entries, 2 per file
+		wantFiles []string
+		wantLines []string
+	}{
+		{"keeps max files", 3, []string{"c.log", "c.log.1", "c.log.2"}, lineRange(4, 9)},
+		{"single file is truncated", 1, []string{"c.log"}, lineRange(8, 9)},
+		{"no max file means one", 0, []string{"c.log"}, lineRange(8, 9)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "c.log")
+			l, err := NewJSONFile(path, 2*entrySize, tt.maxFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeLines(t, l, 0, 10, time.Unix(0, 0))
+			if err := l.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var files []string
+			for _, e := range entries {
+				files = append(files, e.Name())
+				info, err := e.Info()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Size() > 2*entrySize {
+					t.Fatalf("%s is %d bytes, over the max size %d", e.Name(), info.Size(), 2*entrySize)
+				}
+			}
+			if !equal(files, tt.wantFiles) {
+				t.Fatalf("files = %v, want %v", files, tt.wantFiles)
+			}
+
+			if got := readAll(t, path, ReadOptions{Tail: -1}); !equal(got, tt.wantLines) {
+				t.Fatalf("Read() = %v, want %v", got, tt.wantLines)
+			}
+		})
+	}
+}
+
+func TestJSONFileNoRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "c.log")
+	l, err := NewJSONFile(path, 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, l, 0, 10, time.Unix(0, 0))
+	l.Close()
+
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Fatalf("rotated without a max size: %v", err)
+	}
+	if got := readAll(t, path, ReadOptions{Tail: -1}); !equal(got, lineRange(0, 9)) {
+		t.Fatalf("Read() = %v", got)
+	}
+}
+
+func TestJSONFileReopenAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "c.log")
+	for run := 0; run < 2; run++ {
+		l, err := NewJSONFile(path, 3*entrySize, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeLines(t, l, run*2, 2, time.Unix(0, 0))
+		l.Close()
+	}
+
+	// the size of the existing file counts towards the first rotation
+	if got := readAll(t, path+".1", ReadOptions{Tail: -1}); !equal(got, lineRange(0, 2)) {
+		t.Fatalf("rotated file = %v, want lines 0-2", got)
+	}
+	if got := readAll(t, path, ReadOptions{Tail: -1}); !equal(got, lineRange(0, 3)) {
+		t.Fatalf("Read() = %v, want lines 0-3", got)
+	}
+}
+
+func TestStreamWriterSplitsLines(t *testing.T) {
+	long := strings.Repeat("x", 2*maxLineSize+10)
+	tests := []struct {
+		name   string
+		writes []string
+		// entries after Close, in order
+		want []string
+	}{
+		{"lines", []string{"a\nb\n"}, []string{"a\n", "b\n"}},
+		{"line across writes", []string{"he", "llo\nwor", "ld\n"}, []string{"hello\n", "world\n"}},
+		{"partial tail flushed on close", []string{"a\nb"}, []string{"a\n", "b"}},
+		{"empty lines", []string{"\n\n"}, []string{"\n", "\n"}},
+		{"line over the max size", []string{long + "\n"}, []string{long[:maxLineSize], long[maxLineSize : 2*maxLineSize], long[2*maxLineSize:] + "\n"}},
+		{"exactly the max size", []string{strings.Repeat("y", maxLineSize-1) + "\n"}, []string{strings.Repeat("y", maxLineSize-1) + "\n"}},
+		{"long line without newline", []string{long}, []string{long[:maxLineSize], long[maxLineSize : 2*maxLineSize], long[2*maxLineSize:]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "c.log")
+			l, err := NewJSONFile(path, 0, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := l.Writer("stderr")
+			for _, s := range tt.writes {
+				if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+					t.Fatalf("Write() = %d %v, want %d", n, err, len(s))
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			l.Close()
+
+			var got []string
+			err = Read(path, ReadOptions{Tail: -1}, func(e *Entry) error {
+				if e.Stream != "stderr" {
+					t.Fatalf("stream = %q, want stderr", e.Stream)
+				}
+				got = append(got, e.Log)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d entries, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("entry %d = %q (%d bytes), want %q (%d bytes)", i, abbrev(got[i]), len(got[i]), abbrev(tt.want[i]), len(tt.want[i]))
+				}
+			}
+		})
+	}
+}
+
+func abbrev(s string) string {
+	if len(s) > 20 {
+		return s[:20] + "..."
+	}
+	return s
+}
@@ -0,0 +1,225 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const followPollInterval = 200 * time.Millisecond
+
+type ReadOptions struct {
+	// Tail limits the output to the last n entries, negative means all
+	Tail   int
+	Since  time.Time
+	Follow bool
+	// Done reports that the container is gone, following stops once the log is drained
+	Done func() bool
+}
+
+// Read emits the entries of the log at path, including rotated files, oldest first
+func Read(path string, opts ReadOptions, fn func(*Entry) error) error {
+	var entries []*Entry
+	keep := func(e *Entry) error {
+		if !e.Time.Before(opts.Since) {
+			entries = append(entries, e)
+		}
+		return nil
+	}
+
+	for _, rotated := range rotatedFiles(path) {
+		data, err := os.ReadFile(rotated)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// rotated away while we were listing
+				continue
+			}
+			return err
+		}
+		if _, err := parseLines(data, keep); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) && !opts.Follow {
+			return nil
+		}
+		return err
+	}
+	defer func() { f.Close() }()
+
+	r := &fileReader{f: f}
+	if err := r.drain(keep); err != nil {
+		return err
+	}
+
+	if opts.Tail >= 0 && len(entries) > opts.Tail {
+		entries = entries[len(entries)-opts.Tail:]
+	}
+	for _, e := range entries {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	if !opts.Follow {
+		return nil
+	}
+
+	emit := func(e *Entry) error {
+		if e.Time.Before(opts.Since) {
+			return nil
+		}
+		return fn(e)
+	}
+	for {
+		if err := r.drain(emit); err != nil {
+			return err
+		}
+
+		if rotated, err := r.rotated(path); err != nil {
+			return err
+		} else if rotated {
+			// what was left in the old file has been drained above
+			nf, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			f.Close()
+			f = nf
+			r = &fileReader{f: f}
+			continue
+		}
+
+		if opts.Done != nil && opts.Done() {
+			return r.drain(emit)
+		}
+		time.Sleep(followPollInterval)
+	}
+}
+
+// fileReader reads complete JSON lines, holding back a line still being written
+type fileReader struct {
+	f       *os.File
+	pending []byte
+}
+
+func (r *fileReader) drain(fn func(*Entry) error) error {
+	data, err := io.ReadAll(r.f)
+	if err != nil {
+		return err
+	}
+	r.pending = append(r.pending, data...)
+
+	n, err := parseLines(r.pending, fn)
+	r.pending = r.pending[n:]
+	return err
+}
+
+// rotated reports whether path no longer refers to the file being read
+func (r *fileReader) rotated(path string) (bool, error) {
+	cur, err := r.f.Stat()
+	if err != nil {
+		return false, err
+	}
+	latest, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !os.SameFile(cur, latest) {
+		return true, nil
+	}
+
+	// a single file log is truncated instead of renamed
+	offset, err := r.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, err
+	}
+	if latest.Size() < offset {
+		if _, err := r.f.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+		r.pending = nil
+	}
+	return false, nil
+}
+
+// parseLines decodes every complete line in data and returns the bytes consumed
+func parseLines(data []byte, fn func(*Entry) error) (int, error) {
+	consumed := 0
+	for {
+		i := bytes.IndexByte(data[consumed:], '\n')
+		if i < 0 {
+			return consumed, nil
+		}
+
+		line := data[consumed : consumed+i]
+		consumed += i + 1
+		if len(line) == 0 {
+			continue
+		}
+
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			// a torn write, e.g. after a crash, should not hide the rest of the log
+			continue
+		}
+		if err := fn(&e); err != nil {
+			return consumed, err
+		}
+	}
+}
+
+// rotatedFiles lists path.N ... path.1, oldest first
+func rotatedFiles(path string) []string {
+	matches, _ := filepath.Glob(path + ".*")
+
+	type rotated struct {
+		path string
+		n    int
+	}
+	var files []rotated
+	for _, m := range matches {
+		n, err := strconv.Atoi(strings.TrimPrefix(m, path+"."))
+		if err != nil {
+			continue
+		}
+		files = append(files, rotated{m, n})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].n > files[j].n })
+
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+	return paths
+}
+
+// ParseSince accepts RFC3339 timestamps, unix seconds or a duration relative to now
+func ParseSince(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid since value %q, expected RFC3339, unix timestamp or duration", s)
+}
@@ -0,0 +1,201 @@
+package logs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rotatedLog writes lines 0-9 two per file into path, path.1 ... path.4
+func rotatedLog(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "c.log")
+	l, err := NewJSONFile(path, 2*entrySize, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, l, 0, 10, time.Unix(0, 0))
+	l.Close()
+	return path
+}
+
+func TestReadTailAndSince(t *testing.T) {
+	path := rotatedLog(t)
+
+	tests := []struct {
+		name string
+		opts ReadOptions
+		want []string
+	}{
+		{"all", ReadOptions{Tail: -1}, lineRange(0, 9)},
+		{"tail within the current file", ReadOptions{Tail: 2}, lineRange(8, 9)},
+		{"tail across rotated files", ReadOptions{Tail: 5}, lineRange(5, 9)},
+		{"tail over the total", ReadOptions{Tail: 50}, lineRange(0, 9)},
+		{"tail zero", ReadOptions{Tail: 0}, nil},
+		{"since is inclusive", ReadOptions{Tail: -1, Since: time.Unix(3, 0)}, lineRange(3, 9)},
+		{"since in the current file", ReadOptions{Tail: -1, Since: time.Unix(9, 0)}, lineRange(9, 9)},
+		{"since after everything", ReadOptions{Tail: -1, Since: time.Unix(100, 0)}, nil},
+		{"tail applies after since", ReadOptions{Tail: 3, Since: time.Unix(2, 0)}, lineRange(7, 9)},
+		{"since before tail", ReadOptions{Tail: 5, Since: time.Unix(8, 0)}, lineRange(8, 9)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := readAll(t, path, tt.opts); !equal(got, tt.want) {
+				t.Fatalf("Read() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadMissingLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "c.log")
+	if got := readAll(t, path, ReadOptions{Tail: -1}); len(got) != 0 {
+		t.Fatalf("Read() = %v, want nothing", got)
+	}
+}
+
+func TestReadSkipsTornLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "c.log")
+	data := strings.Join([]string{
+		`{"log":"a\n","stream":"stdout","time":"2024-01-01T00:00:00Z"}`,
+		`{"log":"torn`,
+		``,
+		`not json`,
+		`{"log":"b\n","stream":"stdout","time":"2024-01-01T00:00:01Z"}`,
+		// still being written, no newline yet
+		`{"log":"c\n","stream":"stdout"`,
+	}, "\n")
+	if err := os.WriteFile(path, []byte(data), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readAll(t, path, ReadOptions{Tail: -1}); !equal(got, []string{"a", "b"}) {
+		t.Fatalf("Read() = %v, want [a b]", got)
+	}
+}
+
+func TestRotatedFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "c.log")
+	for _, name := range []string{"c.log", "c.log.1", "c.log.10", "c.log.2", "c.log.tmp", "c.log.1.bak", "other.log.1"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := rotatedFiles(path)
+	want := []string{path + ".10", path + ".2", path + ".1"}
+	if !equal(got, want) {
+		t.Fatalf("rotatedFiles() = %v, want %v", got, want)
+	}
+}
+
+func TestReadFollowAcrossRotation(t *testing.T) {
+	for _, maxFile := range []int{1, 3} {
+		t.Run(map[int]string{1: "truncated", 3: "renamed"}[maxFile], func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "c.log")
+			l, err := NewJSONFile(path, 4*entrySize, maxFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			writeLines(t, l, 0, 2, time.Unix(0, 0))
+
+			var mu sync.Mutex
+			var got []string
+			var done atomic.Bool
+			errCh := make(chan error, 1)
+			go func() {
+				errCh <- Read(path, ReadOptions{Tail: -1, Follow: true, Done: done.Load}, func(e *Entry) error {
+					mu.Lock()
+					defer mu.Unlock()
+					got = append(got, strings.TrimSuffix(e.Log, "\n"))
+					return nil
+				})
+			}()
+
+			waitFor := func(n int) {
+				t.Helper()
+				deadline := time.Now().Add(5 * time.Second)
+				for {
+					mu.Lock()
+					count := len(got)
+					mu.Unlock()
+					if count >= n {
+						return
+					}
+					if time.Now().After(deadline) {
+						t.Fatalf("got %d entries, want %d", count, n)
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+
+			waitFor(2)
+			// fills the file, the next write rotates it
+			writeLines(t, l, 2, 2, time.Unix(0, 0))
+			waitFor(4)
+			writeLines(t, l, 4, 2, time.Unix(0, 0))
+			waitFor(6)
+			done.Store(true)
+
+			select {
+			case err := <-errCh:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Read did not return once done")
+			}
+			if !equal(got, lineRange(0, 5)) {
+				t.Fatalf("Read() = %v, want lines 0-5", got)
+			}
+		})
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		in      string
+		want    time.Time
+		approx  bool
+		wantErr bool
+	}{
+		{in: "", want: time.Time{}},
+		{in: "2024-01-02T03:04:05Z", want: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{in: "2024-01-02T03:04:05.5+02:00", want: time.Date(2024, 1, 2, 1, 4, 5, 5e8, time.UTC)},
+		{in: "1700000000", want: time.Unix(1700000000, 0)},
+		{in: "10m", want: now.Add(-10 * time.Minute), approx: true},
+		{in: "1h30m", want: now.Add(-90 * time.Minute), approx: true},
+		{in: "yesterday", wantErr: true},
+		{in: "2024-01-02", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseSince(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseSince() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.approx {
+				if d := got.Sub(tt.want); d < -time.Minute || d > time.Minute {
+					t.Fatalf("ParseSince() = %v, want about %v", got, tt.want)
+				}
+				return
+			}
+			if !got.Equal(tt.want) {
+				t.Fatalf("ParseSince() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
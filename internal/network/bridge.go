@@ -1,81 +1,107 @@
 package network
 
 import (
-	"bufio"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"net"
-	"os"
-	"os/exec"
-	"strconv"
-	"strings"
+	"net/netip"
 
 	"github.com/truongnhatanh7/xocker/internal/logger"
 	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
 )
 
-var (
-	bridge    = "xocker0"
-	networkIP = "172.18.0.0"
-	bridgeIP  = "172.18.0.1/24"
-)
+// CreateBridge creates the bridge of the network if needed and, unless the
+// network opted out of masquerading, makes sure containers on it can reach
+// beyond the host
+func CreateBridge(n *Network) {
+	bridge := n.Bridge
+	bridgeIP := n.GatewayCIDR()
 
-func CreateBridge() {
-	if err := exec.Command("ip", "link", "show", bridge).Run(); err == nil {
+	if LinkExists(bridge) {
 		logger.Log.Debug("bridge already exists, ensuring it's up", zap.String("bridge", bridge))
-		if err := exec.Command("ip", "link", "set", bridge, "up").Run(); err != nil {
+		if err := LinkSetUp(bridge); err != nil {
 			logger.Log.Error("failed to bring existing bridge up", zap.Error(err))
 		}
+		setupOutboundNAT(n)
+		setupIPv6Forward(n)
 		return
 	}
 
 	logger.Log.Info("creating bridge", zap.String("bridge", bridge))
-	if err := exec.Command("ip", "link", "add", "name", bridge, "type", "bridge").Run(); err != nil {
+	if err := LinkAddBridge(bridge); err != nil {
 		logger.Log.Error("failed to create bridge", zap.Error(err))
 		return
 	}
 
-	if err := exec.Command("ip", "addr", "add", bridgeIP, "dev", bridge).Run(); err != nil {
-		logger.Log.Warn("warning: ip addr add may have failed (might already have this IP)", zap.Error(err))
+	prefix, err := netip.ParsePrefix(bridgeIP)
+	if err != nil {
+		logger.Log.Error("invalid bridge address", zap.String("ip", bridgeIP), zap.Error(err))
+		return
+	}
+	if err := AddrAdd(bridge, prefix); err != nil && !errors.Is(err, unix.EEXIST) {
+		logger.Log.Warn("failed to assign the bridge address", zap.Error(err))
+	}
+	if n.HasIPv6() {
+		if prefix, err := netip.ParsePrefix(n.GatewayV6CIDR()); err != nil {
+			logger.Log.Error("invalid bridge IPv6 address", zap.String("ip", n.GatewayV6CIDR()), zap.Error(err))
+		} else if err := AddrAdd(bridge, prefix); err != nil && !errors.Is(err, unix.EEXIST) {
+			logger.Log.Warn("failed to assign the bridge IPv6 address", zap.Error(err))
+		}
 	}
 
-	if err := exec.Command("ip", "link", "set", bridge, "up").Run(); err != nil {
+	if err := LinkSetUp(bridge); err != nil {
 		logger.Log.Error("failed to bring bridge up", zap.Error(err))
 		return
 	}
 
-	logger.Log.Info("bridge created and configured successfully", zap.String("bridge", bridge), zap.String("ip", bridgeIP))
+	logger.Log.Info("bridge created and configured successfully", zap.String("bridge", bridge), zap.String("ip", bridgeIP), zap.String("ipv6", n.GatewayV6CIDR()))
+	setupOutboundNAT(n)
+	setupIPv6Forward(n)
 }
 
-func InitIPState(filename string) error {
-	existingIPs, err := readIPs(filename)
-	if err != nil {
-		return fmt.Errorf("failed to read IP state file: %w", err)
+func setupIPv6Forward(n *Network) {
+	if !n.HasIPv6() {
+		return
 	}
-
-	if len(existingIPs) > 0 {
-		logger.Log.Debug("IP state file already initialized", zap.Int("ipCount", len(existingIPs)))
-		return nil
+	if err := EnableIPv6Forward(); err != nil {
+		logger.Log.Error("failed to enable ipv6 forwarding", zap.Error(err))
+	}
+	// same as IPv4, the forwarded traffic goes through ip6tables
+	if !n.IPMasq {
+		return
+	}
+	if err := SetupIPv6Masquerade(n.Bridge, n.SubnetV6); err != nil {
+		logger.Log.Error("failed to set up IPv6 masquerade", zap.Error(err))
 	}
+}
 
-	baseIP := "172.18.0.1"
-	if err := appendIP(filename, baseIP); err != nil {
-		return fmt.Errorf("failed to initialize IP state with base IP: %w", err)
+func setupOutboundNAT(n *Network) {
+	if !n.IPMasq {
+		logger.Log.Debug("ip masquerade disabled", zap.String("network", n.Name))
+		return
 	}
 
-	logger.Log.Info("initialized IP state file", zap.String("baseIP", baseIP), zap.String("file", filename))
-	return nil
+	if err := EnableIPForward(); err != nil {
+		logger.Log.Error("failed to enable ip forwarding", zap.Error(err))
+	}
+	if err := SetupMasquerade(n.Bridge, n.Subnet); err != nil {
+		logger.Log.Error("failed to set up masquerade", zap.Error(err))
+	}
 }
 
-func ReleaseIP(filename, ip string) error {
-	logger.Log.Debug("releasing IP", zap.String("ip", ip))
-
-	if err := removeIP(filename, ip); err != nil {
-		return fmt.Errorf("failed to release IP %s: %w", ip, err)
+// DeleteVeth removes a veth pair by either of its ends, a missing link is not an error
+func DeleteVeth(name string) error {
+	if err := LinkDel(name); err != nil {
+		if errors.Is(err, ErrLinkNotFound) {
+			logger.Log.Debug("veth already gone", zap.String("veth", name))
+			return nil
+		}
+		return fmt.Errorf("failed to delete veth %s: %w", name, err)
 	}
 
-	logger.Log.Info("released IP", zap.String("ip", ip))
+	logger.Log.Info("deleted veth", zap.String("veth", name))
 	return nil
 }
 
@@ -88,150 +114,67 @@ func randomHex(n int) string {
 	return hex.EncodeToString(bytes)
 }
 
-func CreateVethAndAttachToBridge(pid int) (string, string, error) {
-	hostVeth := fmt.Sprintf("vethh%s", randomHex(6))
-	contVeth := fmt.Sprintf("vethc%s", randomHex(6))
+// CreateVethAndAttachToBridge returns the container IP with prefix, its IPv6
+// address with prefix on dual-stack networks, the host side veth name and the
+// container side veth name
+func CreateVethAndAttachToBridge(containerID string, pid int, n *Network) (contIPWithCIDR string, contIPv6WithCIDR string, hostVeth string, contVeth string, err error) {
+	hostVeth = fmt.Sprintf("vethh%s", randomHex(6))
+	contVeth = fmt.Sprintf("vethc%s", randomHex(6))
 
 	logger.Log.Debug("creating veth pair",
 		zap.String("hostVeth", hostVeth),
 		zap.String("contVeth", contVeth),
 		zap.Int("pid", pid))
 
-	existingIPs, err := readIPs("./ip.state")
-	if err != nil {
-		return "", "", fmt.Errorf("failed to read IP state: %w", err)
-	}
-
-	contIP, err := nextAvailableIP(existingIPs)
+	contIP, contIPv6, err := AllocateIP(n, containerID)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to allocate IP: %w", err)
+		return "", "", "", "", fmt.Errorf("failed to allocate IP: %w", err)
 	}
+	defer func() {
+		if err != nil {
+			ReleaseIP(n, containerID)
+		}
+	}()
 
-	logger.Log.Debug("allocated IP for container", zap.String("ip", contIP))
-
-	if err := exec.Command("ip", "link", "add", hostVeth, "type", "veth", "peer", "name", contVeth).Run(); err != nil {
-		return "", "", fmt.Errorf("failed to create veth pair: %w", err)
-	}
+	logger.Log.Debug("allocated IP for container", zap.String("ip", contIP.String()), zap.String("ipv6", contIPv6.String()))
 
-	if err := exec.Command("ip", "link", "set", hostVeth, "master", bridge).Run(); err != nil {
-		return "", "", fmt.Errorf("failed to attach veth to bridge: %w", err)
+	if err := LinkAddVeth(hostVeth, contVeth); err != nil {
+		return "", "", "", "", fmt.Errorf("failed to create veth pair: %w", err)
 	}
+	defer func() {
+		if err != nil {
+			DeleteVeth(hostVeth)
+		}
+	}()
 
-	if err := exec.Command("ip", "link", "set", hostVeth, "up").Run(); err != nil {
-		return "", "", fmt.Errorf("failed to set host veth up: %w", err)
+	if err := LinkSetMaster(hostVeth, n.Bridge); err != nil {
+		return "", "", "", "", fmt.Errorf("failed to attach veth to bridge: %w", err)
 	}
 
-	if err := exec.Command("ip", "link", "set", contVeth, "netns", strconv.Itoa(pid)).Run(); err != nil {
-		return "", "", fmt.Errorf("failed to move veth to netns: %w", err)
+	if err := LinkSetUp(hostVeth); err != nil {
+		return "", "", "", "", fmt.Errorf("failed to set host veth up: %w", err)
 	}
 
-	if err := appendIP("./ip.state", contIP); err != nil {
-		return "", "", fmt.Errorf("failed to save IP to state: %w", err)
+	if err := LinkSetNsPid(contVeth, pid); err != nil {
+		return "", "", "", "", fmt.Errorf("failed to move veth to netns: %w", err)
 	}
 
 	logger.Log.Info("veth pair created and attached",
 		zap.String("hostVeth", hostVeth),
 		zap.String("contVeth", contVeth),
-		zap.String("containerIP", contIP),
+		zap.String("containerIP", contIP.String()),
 		zap.Int("pid", pid))
 
-	return contIP + "/24", contVeth, nil
-}
-
-func readIPs(filename string) ([]string, error) {
-	// Open file with create flag
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDONLY, 0644)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	var ips []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line != "" {
-			ips = append(ips, line)
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
-
-	return ips, nil
-}
-
-func appendIP(filename, ip string) error {
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	_, err = file.WriteString(ip + "\n")
-	return err
-}
-
-func removeIP(filename, ipToRemove string) error {
-	data, err := os.ReadFile(filename)
+	prefix, err := netip.ParsePrefix(n.Subnet)
 	if err != nil {
-		return err
-	}
-
-	lines := strings.Split(string(data), "\n")
-	var filtered []string
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line != "" && line != ipToRemove {
-			filtered = append(filtered, line)
-		}
-	}
-
-	// Rewrite file
-	output := strings.Join(filtered, "\n")
-	if len(filtered) > 0 {
-		output += "\n"
-	}
-
-	return os.WriteFile(filename, []byte(output), 0644)
-}
-
-func nextAvailableIP(existing []string) (string, error) {
-	if len(existing) == 0 {
-		return "", fmt.Errorf("no base subnet found")
-	}
-
-	// Use first IP to determine subnet base
-	firstIP := net.ParseIP(existing[0])
-	if firstIP == nil {
-		return "", fmt.Errorf("invalid IP in list")
-	}
-
-	base := firstIP.To4()
-	if base == nil {
-		return "", fmt.Errorf("not IPv4")
-	}
-
-	subnetPrefix := fmt.Sprintf("%d.%d.%d.", base[0], base[1], base[2])
-
-	used := make(map[int]bool)
-
-	for _, ipStr := range existing {
-		ip := net.ParseIP(ipStr).To4()
-		if ip == nil {
-			continue
-		}
-		used[int(ip[3])] = true
+		return "", "", "", "", fmt.Errorf("invalid subnet of network %s: %w", n.Name, err)
 	}
-
-	// Search usable host range 1-254
-	for i := 1; i <= 254; i++ {
-		if !used[i] {
-			return subnetPrefix + strconv.Itoa(i), nil
+	if contIPv6.IsValid() {
+		prefixV6, err := netip.ParsePrefix(n.SubnetV6)
+		if err != nil {
+			return "", "", "", "", fmt.Errorf("invalid IPv6 subnet of network %s: %w", n.Name, err)
 		}
+		contIPv6WithCIDR = netip.PrefixFrom(contIPv6, prefixV6.Bits()).String()
 	}
-
-	return "", fmt.Errorf("no available IPs")
+	return netip.PrefixFrom(contIP, prefix.Bits()).String(), contIPv6WithCIDR, hostVeth, contVeth, nil
 }
@@ -2,46 +2,81 @@ package network
 
 import (
 	"fmt"
-	"os/exec"
+	"net/netip"
 
 	"github.com/truongnhatanh7/xocker/internal/logger"
 	"go.uber.org/zap"
 )
 
-func ConfigureContainerNetwork(vethName, ipWithCIDR, gatewayIP string) error {
+// ConfigureContainerNetwork runs inside the container's network namespace, it
+// talks netlink directly so the rootfs doesn't need iproute2. ipv6WithCIDR and
+// gatewayV6 are empty on IPv4 only networks.
+func ConfigureContainerNetwork(vethName, ipWithCIDR, gatewayIP, ipv6WithCIDR, gatewayV6 string) error {
 	logger.Log.Debug("configuring container network",
 		zap.String("veth", vethName),
 		zap.String("ip", ipWithCIDR),
 		zap.String("gateway", gatewayIP))
 
-	cmd := exec.Command("ip", "addr", "add", ipWithCIDR, "dev", vethName)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to assign IP %s to %s: %w, output: %s", ipWithCIDR, vethName, err, string(output))
+	prefix, err := netip.ParsePrefix(ipWithCIDR)
+	if err != nil {
+		return fmt.Errorf("invalid container address %q: %w", ipWithCIDR, err)
+	}
+	gateway, err := netip.ParseAddr(gatewayIP)
+	if err != nil {
+		return fmt.Errorf("invalid gateway %q: %w", gatewayIP, err)
+	}
+
+	if err := AddrAdd(vethName, prefix); err != nil {
+		return fmt.Errorf("failed to assign IP %s to %s: %w", ipWithCIDR, vethName, err)
 	}
 	logger.Log.Debug("assigned IP to veth", zap.String("veth", vethName), zap.String("ip", ipWithCIDR))
 
-	cmd = exec.Command("ip", "link", "set", vethName, "up")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to bring up %s: %w, output: %s", vethName, err, string(output))
+	if err := LinkSetUp(vethName); err != nil {
+		return fmt.Errorf("failed to bring up %s: %w", vethName, err)
 	}
 	logger.Log.Debug("brought up veth interface", zap.String("veth", vethName))
 
-	cmd = exec.Command("ip", "link", "set", "lo", "up")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to bring up loopback interface: %w, output: %s", err, string(output))
+	if err := LinkSetUp("lo"); err != nil {
+		return fmt.Errorf("failed to bring up loopback interface: %w", err)
 	}
 	logger.Log.Debug("brought up loopback interface")
 
-	cmd = exec.Command("ip", "route", "add", "default", "via", gatewayIP)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to add default route via %s: %w, output: %s", gatewayIP, err, string(output))
+	if err := RouteAddDefault(gateway); err != nil {
+		return fmt.Errorf("failed to add default route via %s: %w", gatewayIP, err)
 	}
 	logger.Log.Debug("added default route", zap.String("gateway", gatewayIP))
 
+	if ipv6WithCIDR != "" {
+		if err := configureIPv6(vethName, ipv6WithCIDR, gatewayV6); err != nil {
+			return err
+		}
+	}
+
 	logger.Log.Info("container network configured successfully",
 		zap.String("veth", vethName),
 		zap.String("ip", ipWithCIDR),
-		zap.String("gateway", gatewayIP))
+		zap.String("gateway", gatewayIP),
+		zap.String("ipv6", ipv6WithCIDR))
+
+	return nil
+}
 
+func configureIPv6(vethName, ipWithCIDR, gatewayIP string) error {
+	prefix, err := netip.ParsePrefix(ipWithCIDR)
+	if err != nil {
+		return fmt.Errorf("invalid container IPv6 address %q: %w", ipWithCIDR, err)
+	}
+	gateway, err := netip.ParseAddr(gatewayIP)
+	if err != nil {
+		return fmt.Errorf("invalid IPv6 gateway %q: %w", gatewayIP, err)
+	}
+
+	if err := AddrAdd(vethName, prefix); err != nil {
+		return fmt.Errorf("failed to assign IP %s to %s: %w", ipWithCIDR, vethName, err)
+	}
+	if err := RouteAddDefault(gateway); err != nil {
+		return fmt.Errorf("failed to add IPv6 default route via %s: %w", gatewayIP, err)
+	}
+	logger.Log.Debug("configured IPv6", zap.String("ip", ipWithCIDR), zap.String("gateway", gatewayIP))
 	return nil
 }
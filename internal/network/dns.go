@@ -0,0 +1,214 @@
+package network
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/truongnhatanh7/xocker/internal/dns"
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"github.com/truongnhatanh7/xocker/internal/state"
+	"go.uber.org/zap"
+)
+
+const (
+	dnsPidFile = "dns.pid"
+	dnsLogFile = "dns.log"
+
+	dnsStartTimeout = 3 * time.Second
+	// the server exits once the network has had no containers for this long
+	dnsIdleTimeout = 30 * time.Second
+)
+
+// HasEmbeddedDNS reports whether containers of the network resolve each other
+// by name, like docker only user-defined networks do
+func (n *Network) HasEmbeddedDNS() bool {
+	return n.Name != DefaultNetwork
+}
+
+// dnsRecords serves the names of the containers attached to a network
+type dnsRecords struct {
+	network string
+}
+
+func (r dnsRecords) endpoints() []*state.State {
+	attached, err := Containers(r.network)
+	if err != nil {
+		logger.Log.Warn("failed to list containers", zap.Error(err))
+		return nil
+	}
+	return attached
+}
+
+func endpointNames(s *state.State) []string {
+	names := append([]string{s.Name, state.ShortID(s.ID)}, s.Network.Aliases...)
+	return names
+}
+
+func endpointAddrs(s *state.State) []netip.Addr {
+	var addrs []netip.Addr
+	for _, ip := range []string{s.Network.IP, s.Network.IPv6} {
+		if p, err := netip.ParsePrefix(ip); err == nil {
+			addrs = append(addrs, p.Addr())
+		}
+	}
+	return addrs
+}
+
+func (r dnsRecords) LookupName(name string) []netip.Addr {
+	var addrs []netip.Addr
+	for _, s := range r.endpoints() {
+		for _, n := range endpointNames(s) {
+			if strings.EqualFold(n, name) {
+				addrs = append(addrs, endpointAddrs(s)...)
+				break
+			}
+		}
+	}
+	return addrs
+}
+
+func (r dnsRecords) LookupAddr(addr netip.Addr) []string {
+	for _, s := range r.endpoints() {
+		for _, a := range endpointAddrs(s) {
+			if a == addr {
+				return []string{s.Name}
+			}
+		}
+	}
+	return nil
+}
+
+// RunDNSServer serves the embedded DNS of the network on its gateway until the
+// network is idle or removed, it is run by the hidden dns-server command
+func RunDNSServer(name string) error {
+	n, err := Get(name)
+	if err != nil {
+		return err
+	}
+	gateway, err := netip.ParseAddr(n.Gateway)
+	if err != nil {
+		return fmt.Errorf("invalid gateway of network %s: %w", n.Name, err)
+	}
+
+	srv := &dns.Server{
+		Addr:      netip.AddrPortFrom(gateway, 53),
+		Records:   dnsRecords{network: n.Name},
+		Upstreams: dns.HostResolvConf(false).Nameservers,
+	}
+	if err := srv.Listen(); err != nil {
+		return err
+	}
+	defer srv.Close()
+
+	pidFile := filepath.Join(n.Dir(), dnsPidFile)
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0o644); err != nil {
+		return err
+	}
+	defer os.Remove(pidFile)
+
+	logger.Log.Info("dns server started",
+		zap.String("network", n.Name),
+		zap.String("addr", srv.Addr.String()),
+		zap.Strings("upstreams", srv.Upstreams))
+
+	go watchIdle(n, srv)
+	return srv.Serve()
+}
+
+// watchIdle stops the server once no container is attached to the network
+func watchIdle(n *Network, srv *dns.Server) {
+	lastUsed := time.Now()
+	for range time.Tick(5 * time.Second) {
+		if _, err := Get(n.Name); err != nil {
+			logger.Log.Info("network removed, stopping dns server", zap.String("network", n.Name))
+			srv.Close()
+			return
+		}
+		if attached, err := Containers(n.Name); err == nil && len(attached) > 0 {
+			lastUsed = time.Now()
+			continue
+		}
+		if time.Since(lastUsed) > dnsIdleTimeout {
+			logger.Log.Info("network idle, stopping dns server", zap.String("network", n.Name))
+			srv.Close()
+			return
+		}
+	}
+}
+
+func dnsServerPid(n *Network) int {
+	data, err := os.ReadFile(filepath.Join(n.Dir(), dnsPidFile))
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || !pidAlive(pid) {
+		return 0
+	}
+	return pid
+}
+
+// StartDNS makes sure the embedded DNS server of the network runs, the bridge
+// must already carry the gateway address
+func StartDNS(n *Network) error {
+	if dnsServerPid(n) != 0 {
+		return nil
+	}
+
+	logFile, err := os.OpenFile(filepath.Join(n.Dir(), dnsLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command("/proc/self/exe", "network", "dns-server", n.Name)
+	// the internal variables of run must not leak into the server
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "_") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	// outlive the CLI and its terminal
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start dns server: %w", err)
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	deadline := time.After(dnsStartTimeout)
+	for {
+		select {
+		case err := <-exited:
+			// lost a race with another starter, or the address is taken
+			if dnsServerPid(n) != 0 {
+				return nil
+			}
+			return fmt.Errorf("dns server exited: %v, see %s", err, filepath.Join(n.Dir(), dnsLogFile))
+		case <-deadline:
+			cmd.Process.Kill()
+			return fmt.Errorf("dns server did not start in %s", dnsStartTimeout)
+		case <-time.After(50 * time.Millisecond):
+			if dnsServerPid(n) == cmd.Process.Pid {
+				return nil
+			}
+		}
+	}
+}
+
+// StopDNS stops the embedded DNS server of the network if it runs
+func StopDNS(n *Network) {
+	if pid := dnsServerPid(n); pid != 0 {
+		syscall.Kill(pid, syscall.SIGTERM)
+	}
+}
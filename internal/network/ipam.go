@@ -0,0 +1,224 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"github.com/truongnhatanh7/xocker/internal/state"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	leasesFile = "leases.json"
+	lockFile   = "leases.lock"
+)
+
+// Lease records which container holds an address, Pid is the xocker process
+// supervising it and releasing the lease when the container exits
+type Lease struct {
+	IP          string    `json:"ip"`
+	IPv6        string    `json:"ipv6,omitempty"`
+	ContainerID string    `json:"containerID"`
+	Pid         int       `json:"pid"`
+	Allocated   time.Time `json:"allocated"`
+}
+
+// AllocateIP leases the lowest free address of the network to the container,
+// and the lowest free IPv6 address too on dual-stack networks, v6 is the zero
+// Addr otherwise. Leases whose container is gone or whose supervisor died are
+// reclaimed first.
+func AllocateIP(n *Network, containerID string) (v4 netip.Addr, v6 netip.Addr, err error) {
+	prefix, gateway, err := parsePool(n.Subnet, n.Gateway)
+	if err != nil {
+		return v4, v6, fmt.Errorf("invalid subnet of network %s: %w", n.Name, err)
+	}
+	var prefixV6 netip.Prefix
+	var gatewayV6 netip.Addr
+	if n.HasIPv6() {
+		if prefixV6, gatewayV6, err = parsePool(n.SubnetV6, n.GatewayV6); err != nil {
+			return v4, v6, fmt.Errorf("invalid IPv6 subnet of network %s: %w", n.Name, err)
+		}
+	}
+
+	err = withLeases(n, func(leases []Lease) ([]Lease, error) {
+		leases = reclaim(leases)
+
+		used := map[netip.Addr]bool{gateway: true}
+		if gatewayV6.IsValid() {
+			used[gatewayV6] = true
+		}
+		for _, l := range leases {
+			if l.ContainerID == containerID {
+				return nil, fmt.Errorf("container %s already has address %s", state.ShortID(containerID), l.IP)
+			}
+			for _, ip := range []string{l.IP, l.IPv6} {
+				if a, err := netip.ParseAddr(ip); err == nil {
+					used[a] = true
+				}
+			}
+		}
+
+		lease := Lease{ContainerID: containerID, Pid: os.Getpid(), Allocated: time.Now()}
+		if v4 = freeAddr(prefix, used); !v4.IsValid() {
+			return nil, fmt.Errorf("no available IPs in network %s (%s)", n.Name, n.Subnet)
+		}
+		lease.IP = v4.String()
+		if prefixV6.IsValid() {
+			if v6 = freeAddr(prefixV6, used); !v6.IsValid() {
+				return nil, fmt.Errorf("no available IPv6 addresses in network %s (%s)", n.Name, n.SubnetV6)
+			}
+			lease.IPv6 = v6.String()
+		}
+		return append(leases, lease), nil
+	})
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, err
+	}
+
+	logger.Log.Debug("allocated IP", zap.String("network", n.Name), zap.String("ip", v4.String()), zap.String("ipv6", v6.String()), zap.String("container", containerID))
+	return v4, v6, nil
+}
+
+func parsePool(subnet, gateway string) (netip.Prefix, netip.Addr, error) {
+	prefix, err := netip.ParsePrefix(subnet)
+	if err != nil {
+		return prefix, netip.Addr{}, err
+	}
+	gw, err := netip.ParseAddr(gateway)
+	if err != nil {
+		return prefix, gw, fmt.Errorf("invalid gateway: %w", err)
+	}
+	return prefix, gw, nil
+}
+
+// freeAddr returns the lowest unused address of the prefix, skipping the
+// network address and, for IPv4, the broadcast address. The zero Addr means
+// the prefix is exhausted.
+func freeAddr(prefix netip.Prefix, used map[netip.Addr]bool) netip.Addr {
+	last := lastAddr(prefix)
+	for a := prefix.Masked().Addr().Next(); a.IsValid() && prefix.Contains(a); a = a.Next() {
+		if a == last && a.Is4() {
+			break
+		}
+		if !used[a] {
+			return a
+		}
+	}
+	return netip.Addr{}
+}
+
+// ReleaseIP drops the lease of the container, releasing twice is not an error
+func ReleaseIP(n *Network, containerID string) error {
+	err := withLeases(n, func(leases []Lease) ([]Lease, error) {
+		kept := leases[:0]
+		for _, l := range leases {
+			if l.ContainerID != containerID {
+				kept = append(kept, l)
+			}
+		}
+		return kept, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release IP of %s: %w", state.ShortID(containerID), err)
+	}
+
+	logger.Log.Info("released IP", zap.String("network", n.Name), zap.String("container", containerID))
+	return nil
+}
+
+// Leases returns the current leases of the network
+func Leases(n *Network) ([]Lease, error) {
+	var current []Lease
+	err := withLeases(n, func(leases []Lease) ([]Lease, error) {
+		current = append(current, leases...)
+		return leases, nil
+	})
+	return current, err
+}
+
+// reclaim drops leases that nobody will ever release
+func reclaim(leases []Lease) []Lease {
+	kept := leases[:0]
+	for _, l := range leases {
+		st, err := state.Load(l.ContainerID)
+		if err != nil || st.Status == state.StatusExited || !pidAlive(l.Pid) {
+			logger.Log.Info("reclaiming stale lease", zap.String("ip", l.IP), zap.String("container", l.ContainerID))
+			continue
+		}
+		kept = append(kept, l)
+	}
+	return kept
+}
+
+// withLeases runs fn with the leases of the network under an exclusive flock,
+// the leases returned by fn are written back
+func withLeases(n *Network, fn func([]Lease) ([]Lease, error)) error {
+	if err := os.MkdirAll(n.Dir(), 0o755); err != nil {
+		return err
+	}
+
+	lock, err := os.OpenFile(filepath.Join(n.Dir(), lockFile), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open lease lock: %w", err)
+	}
+	defer lock.Close()
+	if err := unix.Flock(int(lock.Fd()), unix.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock leases: %w", err)
+	}
+	defer unix.Flock(int(lock.Fd()), unix.LOCK_UN)
+
+	path := filepath.Join(n.Dir(), leasesFile)
+	var leases []Lease
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &leases); err != nil {
+			return fmt.Errorf("failed to parse leases of network %s: %w", n.Name, err)
+		}
+	}
+
+	leases, err = fn(leases)
+	if err != nil {
+		return err
+	}
+
+	if leases == nil {
+		leases = []Lease{}
+	}
+	data, err = json.MarshalIndent(leases, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// lastAddr returns the highest address of the prefix
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Masked().Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	last, _ := netip.AddrFromSlice(b)
+	return last
+}
+
+func pidAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,246 @@
+package network
+
+import (
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"github.com/truongnhatanh7/xocker/internal/state"
+	"go.uber.org/zap"
+)
+
+func init() {
+	logger.Log = zap.NewNop()
+}
+
+func addrs(list ...string) map[netip.Addr]bool {
+	used := map[netip.Addr]bool{}
+	for _, a := range list {
+		used[netip.MustParseAddr(a)] = true
+	}
+	return used
+}
+
+func TestFreeAddr(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		used   map[netip.Addr]bool
+		want   string // empty when exhausted
+	}{
+		{"first host", "10.0.0.0/24", addrs(), "10.0.0.1"},
+		{"skips gateway and leases", "10.0.0.0/24", addrs("10.0.0.1", "10.0.0.2"), "10.0.0.3"},
+		{"fills holes", "10.0.0.0/24", addrs("10.0.0.1", "10.0.0.3"), "10.0.0.2"},
+		{"unmasked prefix", "10.0.0.7/24", addrs(), "10.0.0.1"},
+		{"last host before broadcast", "10.0.0.0/30", addrs("10.0.0.1"), "10.0.0.2"},
+		{"never the broadcast", "10.0.0.0/30", addrs("10.0.0.1", "10.0.0.2"), ""},
+		{"/31 has no host", "10.0.0.0/31", addrs(), ""},
+		{"/32 has no host", "10.0.0.0/32", addrs(), ""},
+		{"ipv6 first host", "fd00::/64", addrs("fd00::1"), "fd00::2"},
+		{"ipv6 has no broadcast", "fd00::/126", addrs("fd00::1", "fd00::2"), "fd00::3"},
+		{"ipv6 exhausted", "fd00::/126", addrs("fd00::1", "fd00::2", "fd00::3"), ""},
+		{"/128 has no host", "fd00::1/128", addrs(), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := freeAddr(netip.MustParsePrefix(tt.prefix), tt.used)
+			if tt.want == "" {
+				if got.IsValid() {
+					t.Fatalf("freeAddr() = %s, want none", got)
+				}
+				return
+			}
+			if got != netip.MustParseAddr(tt.want) {
+				t.Fatalf("freeAddr() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLastAddr(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"10.0.0.0/24", "10.0.0.255"},
+		{"10.0.0.9/24", "10.0.0.255"},
+		{"172.16.0.0/12", "172.31.255.255"},
+		{"10.0.0.0/31", "10.0.0.1"},
+		{"10.0.0.4/32", "10.0.0.4"},
+		{"0.0.0.0/0", "255.255.255.255"},
+		{"fd00::/64", "fd00::ffff:ffff:ffff:ffff"},
+		{"fd00::/127", "fd00::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			if got := lastAddr(netip.MustParsePrefix(tt.prefix)); got != netip.MustParseAddr(tt.want) {
+				t.Fatalf("lastAddr() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSubnetTooSmall(t *testing.T) {
+	tests := []struct {
+		subnet string
+		v6     bool
+		ok     bool
+	}{
+		{"10.0.0.0/30", false, true},
+		{"10.0.0.0/31", false, false},
+		{"10.0.0.0/32", false, false},
+		{"fd00::/126", true, true},
+		{"fd00::/127", true, false},
+		{"fd00::/128", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subnet, func(t *testing.T) {
+			_, _, err := parseSubnet(tt.subnet, "", tt.v6)
+			if tt.ok && err != nil {
+				t.Fatalf("parseSubnet() = %v, want no error", err)
+			}
+			if !tt.ok && (err == nil || !strings.Contains(err.Error(), "too small")) {
+				t.Fatalf("parseSubnet() = %v, want too small", err)
+			}
+		})
+	}
+}
+
+// testNetwork points the network and container stores at temp dirs
+func testNetwork(t *testing.T, subnet, gateway, subnetV6, gatewayV6 string) *Network {
+	t.Helper()
+	networksDir, containersDir := NetworksDir, state.ContainersDir
+	NetworksDir, state.ContainersDir = t.TempDir(), t.TempDir()
+	t.Cleanup(func() {
+		NetworksDir, state.ContainersDir = networksDir, containersDir
+	})
+	return &Network{Name: "test", Subnet: subnet, Gateway: gateway, SubnetV6: subnetV6, GatewayV6: gatewayV6}
+}
+
+// saveState records a container as status, leases of missing containers are reclaimed
+func saveState(t *testing.T, id, status string) {
+	t.Helper()
+	st := &state.State{ID: id, Name: id, Status: status}
+	if err := st.Save(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAllocateIP(t *testing.T) {
+	n := testNetwork(t, "10.0.0.0/29", "10.0.0.1", "fd00::/126", "fd00::1")
+
+	saveState(t, "a", state.StatusRunning)
+	v4, v6, err := AllocateIP(n, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v4 != netip.MustParseAddr("10.0.0.2") || v6 != netip.MustParseAddr("fd00::2") {
+		t.Fatalf("AllocateIP() = %s %s, want 10.0.0.2 fd00::2", v4, v6)
+	}
+
+	if _, _, err := AllocateIP(n, "a"); err == nil || !strings.Contains(err.Error(), "already has address") {
+		t.Fatalf("second allocation for the same container = %v, want already has address", err)
+	}
+
+	saveState(t, "b", state.StatusRunning)
+	if v4, v6, err = AllocateIP(n, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if v4 != netip.MustParseAddr("10.0.0.3") || v6 != netip.MustParseAddr("fd00::3") {
+		t.Fatalf("AllocateIP() = %s %s, want 10.0.0.3 fd00::3", v4, v6)
+	}
+
+	// the IPv6 pool runs out first
+	saveState(t, "c", state.StatusRunning)
+	if _, _, err := AllocateIP(n, "c"); err == nil || !strings.Contains(err.Error(), "no available IPv6") {
+		t.Fatalf("AllocateIP() = %v, want IPv6 exhaustion", err)
+	}
+
+	if err := ReleaseIP(n, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReleaseIP(n, "a"); err != nil {
+		t.Fatalf("releasing twice = %v, want no error", err)
+	}
+	if v4, v6, err = AllocateIP(n, "c"); err != nil {
+		t.Fatal(err)
+	}
+	if v4 != netip.MustParseAddr("10.0.0.2") || v6 != netip.MustParseAddr("fd00::2") {
+		t.Fatalf("AllocateIP() = %s %s, want the released 10.0.0.2 fd00::2", v4, v6)
+	}
+}
+
+func TestAllocateIPExhausted(t *testing.T) {
+	// .0 network, .1 gateway, .3 broadcast: a single address to lease
+	n := testNetwork(t, "10.0.0.0/30", "10.0.0.1", "", "")
+
+	saveState(t, "a", state.StatusRunning)
+	v4, v6, err := AllocateIP(n, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v4 != netip.MustParseAddr("10.0.0.2") || v6.IsValid() {
+		t.Fatalf("AllocateIP() = %s %s, want 10.0.0.2 and no IPv6", v4, v6)
+	}
+
+	saveState(t, "b", state.StatusRunning)
+	if _, _, err := AllocateIP(n, "b"); err == nil || !strings.Contains(err.Error(), "no available IPs") {
+		t.Fatalf("AllocateIP() = %v, want exhaustion", err)
+	}
+}
+
+func TestAllocateIPReclaimsDeadLeases(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, n *Network)
+	}{
+		{"container exited", func(t *testing.T, n *Network) {
+			saveState(t, "old", state.StatusExited)
+		}},
+		{"container state removed", func(t *testing.T, n *Network) {}},
+		{"supervisor dead", func(t *testing.T, n *Network) {
+			saveState(t, "old", state.StatusRunning)
+			err := withLeases(n, func(leases []Lease) ([]Lease, error) {
+				// pid 0 is never a live supervisor
+				leases[0].Pid = 0
+				return leases, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := testNetwork(t, "10.0.0.0/30", "10.0.0.1", "", "")
+
+			saveState(t, "old", state.StatusRunning)
+			if _, _, err := AllocateIP(n, "old"); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.RemoveAll(state.Dir("old")); err != nil {
+				t.Fatal(err)
+			}
+			tt.setup(t, n)
+
+			saveState(t, "new", state.StatusRunning)
+			v4, _, err := AllocateIP(n, "new")
+			if err != nil {
+				t.Fatalf("AllocateIP() = %v, want the stale lease reclaimed", err)
+			}
+			if v4 != netip.MustParseAddr("10.0.0.2") {
+				t.Fatalf("AllocateIP() = %s, want 10.0.0.2", v4)
+			}
+
+			leases, err := Leases(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(leases) != 1 || leases[0].ContainerID != "new" {
+				t.Fatalf("leases = %+v, want only the new container", leases)
+			}
+		})
+	}
+}
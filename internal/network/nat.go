@@ -0,0 +1,120 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	ipForwardPath   = "/proc/sys/net/ipv4/ip_forward"
+	ipv6ForwardPath = "/proc/sys/net/ipv6/conf/all/forwarding"
+)
+
+func bridgeComment(bridgeName string) string {
+	return "xocker-bridge:" + bridgeName
+}
+
+// EnableIPForward turns on IPv4 forwarding, needed to route container traffic
+// out of the host
+func EnableIPForward() error {
+	return enableForwarding(ipForwardPath)
+}
+
+// EnableIPv6Forward turns on IPv6 forwarding for dual-stack networks
+func EnableIPv6Forward() error {
+	return enableForwarding(ipv6ForwardPath)
+}
+
+func enableForwarding(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if strings.TrimSpace(string(data)) == "1" {
+		return nil
+	}
+
+	if err := writeSysctl(path, "1"); err != nil {
+		return err
+	}
+	logger.Log.Info("enabled forwarding", zap.String("sysctl", path))
+	return nil
+}
+
+// SetupMasquerade installs the outbound NAT rule for the bridge subnet and lets
+// the bridge traffic through a FORWARD chain with a drop policy. Rules already
+// in place are left untouched, so it can run on every container start.
+func SetupMasquerade(bridgeName, subnet string) error {
+	if err := ensureRules("iptables", masqueradeRules(bridgeName, subnet)); err != nil {
+		return fmt.Errorf("failed to set up masquerade for %s: %w", bridgeName, err)
+	}
+	logger.Log.Debug("masquerade configured", zap.String("bridge", bridgeName), zap.String("subnet", subnet))
+	return nil
+}
+
+// SetupIPv6Masquerade installs the same rules as SetupMasquerade through
+// ip6tables, for the IPv6 subnet of a dual-stack network
+func SetupIPv6Masquerade(bridgeName, subnetV6 string) error {
+	if err := ensureRules("ip6tables", masqueradeRules(bridgeName, subnetV6)); err != nil {
+		return fmt.Errorf("failed to set up IPv6 masquerade for %s: %w", bridgeName, err)
+	}
+	logger.Log.Debug("IPv6 masquerade configured", zap.String("bridge", bridgeName), zap.String("subnet", subnetV6))
+	return nil
+}
+
+func masqueradeRules(bridgeName, subnet string) []iptablesRule {
+	comment := []string{"-m", "comment", "--comment", bridgeComment(bridgeName)}
+
+	return []iptablesRule{
+		// traffic leaving the subnet through another interface gets the host address
+		{"nat", "-A", "POSTROUTING", append(append([]string{"-s", subnet, "!", "-o", bridgeName}, comment...), "-j", "MASQUERADE")},
+		{"filter", "-I", "FORWARD", append(append([]string{"-i", bridgeName, "!", "-o", bridgeName}, comment...), "-j", "ACCEPT")},
+		{"filter", "-I", "FORWARD", append(append([]string{"-o", bridgeName, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED"}, comment...), "-j", "ACCEPT")},
+	}
+}
+
+// ensureRules adds the rules missing from cmd, iptables or ip6tables
+func ensureRules(cmd string, rules []iptablesRule) error {
+	for _, rule := range rules {
+		check := append([]string{"-t", rule.table, "-C", rule.chain}, rule.args...)
+		if exec.Command(cmd, check...).Run() == nil {
+			continue
+		}
+		if err := xtables(cmd, append([]string{"-t", rule.table, rule.op, rule.chain}, rule.args...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TeardownBridge removes the NAT rules of a network's bridge and the bridge
+// itself, a missing bridge is not an error. ip_forward is left on, other
+// software on the host may rely on it.
+func TeardownBridge(n *Network) error {
+	bridgeName := n.Bridge
+	if err := deleteRulesByComment(bridgeComment(bridgeName)); err != nil {
+		return err
+	}
+	if n.HasIPv6() {
+		if err := deleteRules("ip6tables", bridgeComment(bridgeName)); err != nil {
+			return err
+		}
+	}
+
+	if err := LinkDel(bridgeName); err != nil {
+		if errors.Is(err, ErrLinkNotFound) {
+			logger.Log.Debug("bridge already gone", zap.String("bridge", bridgeName))
+			return nil
+		}
+		return fmt.Errorf("failed to delete bridge %s: %w", bridgeName, err)
+	}
+
+	logger.Log.Info("deleted bridge", zap.String("bridge", bridgeName))
+	return nil
+}
@@ -0,0 +1,24 @@
+package network
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMasqueradeRules(t *testing.T) {
+	rules := masqueradeRules("br0", "10.0.0.0/24")
+	if len(rules) == 0 {
+		t.Fatal("no masquerade rules")
+	}
+	for _, r := range rules {
+		// TeardownBridge deletes the rules by their comment
+		if args := strings.Join(r.args, " "); !strings.Contains(args, "--comment "+bridgeComment("br0")) {
+			t.Errorf("rule %s %s has no bridge comment", r.chain, args)
+		}
+	}
+
+	masq := strings.Join(rules[0].args, " ")
+	if rules[0].table != "nat" || !strings.Contains(masq, "-s 10.0.0.0/24 ! -o br0") || !strings.HasSuffix(masq, "-j MASQUERADE") {
+		t.Fatalf("masquerade rule = %s %s, want traffic of the subnet leaving the bridge", rules[0].table, masq)
+	}
+}
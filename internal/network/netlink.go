@@ -0,0 +1,330 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync/atomic"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// minimal rtnetlink client over a raw NETLINK_ROUTE socket, enough to manage
+// bridges, veths, addresses and routes without iproute2
+
+// ErrLinkNotFound is returned when no link has the requested name
+var ErrLinkNotFound = errors.New("link not found")
+
+// NetlinkError is a request rejected by the kernel, Err is the errno
+type NetlinkError struct {
+	Op  string
+	Err error
+}
+
+func (e *NetlinkError) Error() string {
+	return fmt.Sprintf("netlink %s: %v", e.Op, e.Err)
+}
+
+func (e *NetlinkError) Unwrap() error {
+	return e.Err
+}
+
+// from linux/veth.h, not exported by x/sys/unix
+const vethInfoPeer = 1
+
+var nlSeq uint32
+
+type nlAttrs struct {
+	buf []byte
+}
+
+func (a *nlAttrs) add(typ uint16, value []byte) {
+	hdr := make([]byte, unix.SizeofRtAttr)
+	binary.NativeEndian.PutUint16(hdr[0:2], uint16(unix.SizeofRtAttr+len(value)))
+	binary.NativeEndian.PutUint16(hdr[2:4], typ)
+	a.buf = append(a.buf, hdr...)
+	a.buf = append(a.buf, value...)
+	a.buf = append(a.buf, make([]byte, nlAlign(len(value))-len(value))...)
+}
+
+func (a *nlAttrs) addString(typ uint16, s string) {
+	a.add(typ, append([]byte(s), 0))
+}
+
+func (a *nlAttrs) addUint32(typ uint16, v uint32) {
+	b := make([]byte, 4)
+	binary.NativeEndian.PutUint32(b, v)
+	a.add(typ, b)
+}
+
+func (a *nlAttrs) nest(typ uint16, fn func(*nlAttrs)) {
+	var inner nlAttrs
+	fn(&inner)
+	a.add(typ|unix.NLA_F_NESTED, inner.buf)
+}
+
+func nlAlign(n int) int {
+	return (n + unix.NLA_ALIGNTO - 1) &^ (unix.NLA_ALIGNTO - 1)
+}
+
+func ifInfomsg(family uint8, index int32, flags, change uint32) []byte {
+	b := make([]byte, unix.SizeofIfInfomsg)
+	b[0] = family
+	binary.NativeEndian.PutUint32(b[4:8], uint32(index))
+	binary.NativeEndian.PutUint32(b[8:12], flags)
+	binary.NativeEndian.PutUint32(b[12:16], change)
+	return b
+}
+
+// nlRequest sends one message and waits for the kernel's answer, returning
+// the payloads of the non-error replies
+func nlRequest(op string, msgType uint16, flags uint16, data []byte) ([][]byte, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, &NetlinkError{Op: op, Err: err}
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, &NetlinkError{Op: op, Err: err}
+	}
+
+	seq := atomic.AddUint32(&nlSeq, 1)
+	msg := make([]byte, unix.NLMSG_HDRLEN, unix.NLMSG_HDRLEN+len(data))
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(unix.NLMSG_HDRLEN+len(data)))
+	binary.NativeEndian.PutUint16(msg[4:6], msgType)
+	binary.NativeEndian.PutUint16(msg[6:8], flags|unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	binary.NativeEndian.PutUint32(msg[8:12], seq)
+	msg = append(msg, data...)
+
+	if err := unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, &NetlinkError{Op: op, Err: err}
+	}
+
+	var replies [][]byte
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, &NetlinkError{Op: op, Err: err}
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, &NetlinkError{Op: op, Err: err}
+		}
+
+		for _, m := range msgs {
+			if m.Header.Seq != seq {
+				continue
+			}
+			switch m.Header.Type {
+			case unix.NLMSG_DONE:
+				return replies, nil
+			case unix.NLMSG_ERROR:
+				if len(m.Data) < 4 {
+					return nil, &NetlinkError{Op: op, Err: unix.EINVAL}
+				}
+				if errno := -int32(binary.NativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+					return nil, &NetlinkError{Op: op, Err: unix.Errno(errno)}
+				}
+				// ack, the request is complete
+				return replies, nil
+			default:
+				// buf is reused by the next read
+				replies = append(replies, append([]byte(nil), m.Data...))
+			}
+		}
+	}
+}
+
+// LinkIndex resolves a link name in the current network namespace
+func LinkIndex(name string) (int, error) {
+	var attrs nlAttrs
+	attrs.addString(unix.IFLA_IFNAME, name)
+	data := append(ifInfomsg(unix.AF_UNSPEC, 0, 0, 0), attrs.buf...)
+
+	replies, err := nlRequest("get link "+name, unix.RTM_GETLINK, 0, data)
+	if err != nil {
+		if errors.Is(err, unix.ENODEV) {
+			return 0, fmt.Errorf("%w: %s", ErrLinkNotFound, name)
+		}
+		return 0, err
+	}
+	for _, r := range replies {
+		if len(r) >= unix.SizeofIfInfomsg {
+			return int(int32(binary.NativeEndian.Uint32(r[4:8]))), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrLinkNotFound, name)
+}
+
+// LinkExists reports whether a link with the name exists
+func LinkExists(name string) bool {
+	_, err := LinkIndex(name)
+	return err == nil
+}
+
+// LinkAddBridge creates a bridge device
+func LinkAddBridge(name string) error {
+	var attrs nlAttrs
+	attrs.addString(unix.IFLA_IFNAME, name)
+	attrs.nest(unix.IFLA_LINKINFO, func(info *nlAttrs) {
+		info.addString(unix.IFLA_INFO_KIND, "bridge")
+	})
+	data := append(ifInfomsg(unix.AF_UNSPEC, 0, 0, 0), attrs.buf...)
+
+	_, err := nlRequest("add bridge "+name, unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL, data)
+	return err
+}
+
+// LinkAddVeth creates a veth pair
+func LinkAddVeth(name, peer string) error {
+	var attrs nlAttrs
+	attrs.addString(unix.IFLA_IFNAME, name)
+	attrs.nest(unix.IFLA_LINKINFO, func(info *nlAttrs) {
+		info.addString(unix.IFLA_INFO_KIND, "veth")
+		info.nest(unix.IFLA_INFO_DATA, func(vethData *nlAttrs) {
+			var peerAttrs nlAttrs
+			peerAttrs.addString(unix.IFLA_IFNAME, peer)
+			vethData.add(vethInfoPeer, append(ifInfomsg(unix.AF_UNSPEC, 0, 0, 0), peerAttrs.buf...))
+		})
+	})
+	data := append(ifInfomsg(unix.AF_UNSPEC, 0, 0, 0), attrs.buf...)
+
+	_, err := nlRequest("add veth "+name, unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL, data)
+	return err
+}
+
+// setLink applies attributes and flags to an existing link
+func setLink(op, name string, flags, change uint32, attrs nlAttrs) error {
+	index, err := LinkIndex(name)
+	if err != nil {
+		return err
+	}
+	data := append(ifInfomsg(unix.AF_UNSPEC, int32(index), flags, change), attrs.buf...)
+	_, err = nlRequest(op+" "+name, unix.RTM_NEWLINK, 0, data)
+	return err
+}
+
+// LinkSetUp brings a link up
+func LinkSetUp(name string) error {
+	return setLink("set up", name, unix.IFF_UP, unix.IFF_UP, nlAttrs{})
+}
+
+// LinkSetMaster enslaves a link to a bridge
+func LinkSetMaster(name, master string) error {
+	masterIndex, err := LinkIndex(master)
+	if err != nil {
+		return err
+	}
+	var attrs nlAttrs
+	attrs.addUint32(unix.IFLA_MASTER, uint32(masterIndex))
+	return setLink("set master", name, 0, 0, attrs)
+}
+
+// LinkSetNsPid moves a link into the network namespace of a process
+func LinkSetNsPid(name string, pid int) error {
+	var attrs nlAttrs
+	attrs.addUint32(unix.IFLA_NET_NS_PID, uint32(pid))
+	return setLink("set netns", name, 0, 0, attrs)
+}
+
+// LinkDel deletes a link, for a veth both ends are gone afterwards
+func LinkDel(name string) error {
+	index, err := LinkIndex(name)
+	if err != nil {
+		return err
+	}
+	_, err = nlRequest("delete link "+name, unix.RTM_DELLINK, 0, ifInfomsg(unix.AF_UNSPEC, int32(index), 0, 0))
+	return err
+}
+
+func addrFamily(a netip.Addr) uint8 {
+	if a.Is4() {
+		return unix.AF_INET
+	}
+	return unix.AF_INET6
+}
+
+// AddrAdd assigns an address with its prefix length to a link
+func AddrAdd(name string, prefix netip.Prefix) error {
+	index, err := LinkIndex(name)
+	if err != nil {
+		return err
+	}
+
+	addr := prefix.Addr()
+	msg := make([]byte, unix.SizeofIfAddrmsg)
+	msg[0] = addrFamily(addr)
+	msg[1] = uint8(prefix.Bits())
+	msg[3] = unix.RT_SCOPE_UNIVERSE
+	binary.NativeEndian.PutUint32(msg[4:8], uint32(index))
+
+	var attrs nlAttrs
+	attrs.add(unix.IFA_LOCAL, addr.AsSlice())
+	attrs.add(unix.IFA_ADDRESS, addr.AsSlice())
+	if addr.Is6() {
+		// skip duplicate address detection, the address would stay tentative
+		// and unusable for a second or two
+		attrs.addUint32(unix.IFA_FLAGS, unix.IFA_F_NODAD)
+	}
+
+	_, err = nlRequest("add address "+prefix.String(), unix.RTM_NEWADDR, unix.NLM_F_CREATE|unix.NLM_F_EXCL, append(msg, attrs.buf...))
+	return err
+}
+
+// RouteAddDefault adds a default route via the gateway to the main table
+func RouteAddDefault(gateway netip.Addr) error {
+	msg := make([]byte, unix.SizeofRtMsg)
+	msg[0] = addrFamily(gateway)
+	msg[4] = unix.RT_TABLE_MAIN
+	msg[5] = unix.RTPROT_BOOT
+	msg[6] = unix.RT_SCOPE_UNIVERSE
+	msg[7] = unix.RTN_UNICAST
+
+	var attrs nlAttrs
+	attrs.add(unix.RTA_GATEWAY, gateway.AsSlice())
+
+	_, err := nlRequest("add default route via "+gateway.String(), unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_EXCL, append(msg, attrs.buf...))
+	return err
+}
+
+// RouteList returns the destinations of the unicast routes in the main table
+// for a family, default routes are left out
+func RouteList(family uint8) ([]netip.Prefix, error) {
+	msg := make([]byte, unix.SizeofRtMsg)
+	msg[0] = family
+
+	replies, err := nlRequest("list routes", unix.RTM_GETROUTE, unix.NLM_F_DUMP, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var prefixes []netip.Prefix
+	for _, r := range replies {
+		if len(r) < unix.SizeofRtMsg {
+			continue
+		}
+		dstLen, table, typ := int(r[1]), r[4], r[7]
+		if dstLen == 0 || table != unix.RT_TABLE_MAIN || typ != unix.RTN_UNICAST {
+			continue
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(&syscall.NetlinkMessage{
+			Header: syscall.NlMsghdr{Type: unix.RTM_NEWROUTE},
+			Data:   r,
+		})
+		if err != nil {
+			return nil, &NetlinkError{Op: "list routes", Err: err}
+		}
+		for _, a := range attrs {
+			if a.Attr.Type != unix.RTA_DST {
+				continue
+			}
+			if addr, ok := netip.AddrFromSlice(a.Value); ok {
+				prefixes = append(prefixes, netip.PrefixFrom(addr, dstLen).Masked())
+			}
+		}
+	}
+	return prefixes, nil
+}
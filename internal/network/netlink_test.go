@@ -0,0 +1,49 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestNlAttrs(t *testing.T) {
+	var attrs nlAttrs
+	attrs.addString(unix.IFLA_IFNAME, "br0")
+	attrs.addUint32(unix.IFLA_MTU, 1500)
+	attrs.nest(unix.IFLA_LINKINFO, func(info *nlAttrs) {
+		info.addString(unix.IFLA_INFO_KIND, "veth")
+	})
+
+	var want []byte
+	// header length 8 covers "br0\0", no padding needed
+	want = append(want, u16(8)...)
+	want = append(want, u16(unix.IFLA_IFNAME)...)
+	want = append(want, 'b', 'r', '0', 0)
+	want = append(want, u16(8)...)
+	want = append(want, u16(unix.IFLA_MTU)...)
+	want = append(want, u32(1500)...)
+	// the padding of "veth\0" counts in the length of the nest, not in its own
+	want = append(want, u16(4+12)...)
+	want = append(want, u16(unix.IFLA_LINKINFO|unix.NLA_F_NESTED)...)
+	want = append(want, u16(4+5)...)
+	want = append(want, u16(unix.IFLA_INFO_KIND)...)
+	want = append(want, 'v', 'e', 't', 'h', 0, 0, 0, 0)
+
+	if !bytes.Equal(attrs.buf, want) {
+		t.Fatalf("nlAttrs = %x, want %x", attrs.buf, want)
+	}
+}
+
+func u16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.NativeEndian.PutUint16(b, v)
+	return b
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.NativeEndian.PutUint32(b, v)
+	return b
+}
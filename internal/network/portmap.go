@@ -0,0 +1,254 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"github.com/truongnhatanh7/xocker/internal/state"
+	"go.uber.org/zap"
+)
+
+// every rule carries this comment, so the rules of a container can be found
+// and removed even without knowing its port mappings
+func ruleComment(containerID string) string {
+	return "xocker:" + state.ShortID(containerID)
+}
+
+// ParsePortMapping parses [hostIP:]hostPort:containerPort[/tcp|udp], an IPv6
+// host IP is written in brackets like [::1]:8080:80
+func ParsePortMapping(spec string) (state.PortMapping, error) {
+	p := state.PortMapping{Protocol: "tcp"}
+
+	ports, proto, hasProto := strings.Cut(spec, "/")
+	if hasProto {
+		if proto != "tcp" && proto != "udp" {
+			return p, fmt.Errorf("invalid protocol %q in %q, expected tcp or udp", proto, spec)
+		}
+		p.Protocol = proto
+	}
+
+	if strings.HasPrefix(ports, "[") {
+		hostIP, rest, ok := strings.Cut(ports[1:], "]:")
+		if !ok || net.ParseIP(hostIP) == nil || net.ParseIP(hostIP).To4() != nil {
+			return p, fmt.Errorf("invalid IPv6 host IP in %q", spec)
+		}
+		p.HostIP = hostIP
+		ports = rest
+	}
+
+	parts := strings.Split(ports, ":")
+	switch {
+	case len(parts) == 2:
+	case len(parts) == 3 && p.HostIP == "":
+		if net.ParseIP(parts[0]).To4() == nil {
+			return p, fmt.Errorf("invalid host IP %q in %q, IPv6 addresses go in brackets", parts[0], spec)
+		}
+		p.HostIP = parts[0]
+		parts = parts[1:]
+	default:
+		return p, fmt.Errorf("invalid port mapping %q, expected [hostIP:]hostPort:containerPort[/proto]", spec)
+	}
+
+	hostPort, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil || hostPort == 0 {
+		return p, fmt.Errorf("invalid host port %q in %q", parts[0], spec)
+	}
+	containerPort, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil || containerPort == 0 {
+		return p, fmt.Errorf("invalid container port %q in %q", parts[1], spec)
+	}
+	p.HostPort = uint16(hostPort)
+	p.ContainerPort = uint16(containerPort)
+	return p, nil
+}
+
+// PublishPorts installs the DNAT rules forwarding host ports to the container,
+// for outside traffic as well as for connections from the host itself. On
+// dual-stack networks they are mirrored through ip6tables to containerIPv6,
+// empty otherwise.
+func PublishPorts(containerID, containerIP, containerIPv6, hostVeth, bridgeName string, ports []state.PortMapping) error {
+	if len(ports) == 0 {
+		return nil
+	}
+	for _, p := range ports {
+		if isIPv6(p.HostIP) && containerIPv6 == "" {
+			return fmt.Errorf("can't publish %s, the network has no IPv6 subnet", p)
+		}
+	}
+
+	// allow DNAT of 127.0.0.1 to the bridge, so localhost:hostPort works
+	if err := writeSysctl(filepath.Join("/proc/sys/net/ipv4/conf", bridgeName, "route_localnet"), "1"); err != nil {
+		return err
+	}
+	// hairpin: the container can reach itself through its published port
+	if err := writeSysctl(filepath.Join("/sys/class/net", hostVeth, "brport/hairpin_mode"), "1"); err != nil {
+		return err
+	}
+
+	for _, p := range ports {
+		var rules []iptablesRule
+		if !isIPv6(p.HostIP) {
+			rules = portRules(containerID, containerIP, bridgeName, p)
+		}
+		var rulesV6 []iptablesRule
+		if containerIPv6 != "" && (p.HostIP == "" || isIPv6(p.HostIP)) {
+			rulesV6 = portRules(containerID, containerIPv6, bridgeName, p)
+		}
+		if err := addRules("iptables", rules); err != nil {
+			UnpublishPorts(containerID, containerIPv6 != "")
+			return fmt.Errorf("failed to publish %s: %w", p, err)
+		}
+		if err := addRules("ip6tables", rulesV6); err != nil {
+			UnpublishPorts(containerID, containerIPv6 != "")
+			return fmt.Errorf("failed to publish %s over IPv6: %w", p, err)
+		}
+		logger.Log.Info("published port", zap.String("port", p.String()), zap.String("ip", containerIP), zap.String("ipv6", containerIPv6))
+	}
+	return nil
+}
+
+func addRules(cmd string, rules []iptablesRule) error {
+	for _, rule := range rules {
+		if err := xtables(cmd, append([]string{"-t", rule.table, rule.op, rule.chain}, rule.args...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isIPv6(ip string) bool {
+	return strings.Contains(ip, ":")
+}
+
+type iptablesRule struct {
+	table string
+	op    string
+	chain string
+	args  []string
+}
+
+func portRules(containerID, containerIP, bridgeName string, p state.PortMapping) []iptablesRule {
+	comment := []string{"-m", "comment", "--comment", ruleComment(containerID)}
+	dest := net.JoinHostPort(containerIP, strconv.Itoa(int(p.ContainerPort)))
+	proto := []string{"-p", p.Protocol, "-m", p.Protocol}
+
+	// traffic addressed to one of the host's addresses, or to the given host IP.
+	// 0.0.0.0 and :: are every address of their family.
+	match := append(append([]string{}, proto...), "--dport", strconv.Itoa(int(p.HostPort)))
+	if p.HostIP != "" && !net.ParseIP(p.HostIP).IsUnspecified() {
+		match = append(match, "-d", p.HostIP)
+	} else {
+		match = append(match, "-m", "addrtype", "--dst-type", "LOCAL")
+	}
+	dnat := append(append(append([]string{}, match...), comment...), "-j", "DNAT", "--to-destination", dest)
+
+	toContainer := append(append([]string{}, proto...), "-d", containerIP, "--dport", strconv.Itoa(int(p.ContainerPort)))
+
+	rules := []iptablesRule{
+		// from outside
+		{"nat", "-A", "PREROUTING", dnat},
+		// from the host itself
+		{"nat", "-A", "OUTPUT", dnat},
+		// hairpin, the container connecting to itself through the host port
+		{"nat", "-A", "POSTROUTING", append(append(append([]string{"-s", containerIP}, toContainer...), comment...), "-j", "MASQUERADE")},
+		// let it through a FORWARD chain with a drop policy
+		{"filter", "-I", "FORWARD", append(append(append([]string{"-o", bridgeName}, toContainer...), comment...), "-j", "ACCEPT")},
+	}
+	if !isIPv6(containerIP) {
+		// localhost sources can't be routed to the bridge, masquerade them. IPv6
+		// has no route_localnet, ::1 can't be forwarded at all.
+		rules = append(rules, iptablesRule{"nat", "-A", "POSTROUTING", append(append(append([]string{"-s", "127.0.0.0/8", "-o", bridgeName}, toContainer...), comment...), "-j", "MASQUERADE")})
+	}
+	return rules
+}
+
+// UnpublishPorts removes every rule installed for the container, also through
+// ip6tables on dual-stack networks. It is idempotent and does not need the
+// original port mappings.
+func UnpublishPorts(containerID string, ipv6 bool) error {
+	err := deleteRulesByComment(ruleComment(containerID))
+	if ipv6 {
+		if errV6 := deleteRules("ip6tables", ruleComment(containerID)); err == nil {
+			err = errV6
+		}
+	}
+	return err
+}
+
+// deleteRulesByComment deletes the nat and filter rules tagged with comment
+func deleteRulesByComment(comment string) error {
+	return deleteRules("iptables", comment)
+}
+
+// deleteRules deletes the rules tagged with comment through cmd, iptables or ip6tables
+func deleteRules(cmd, comment string) error {
+	var firstErr error
+	for _, table := range []string{"nat", "filter"} {
+		out, err := exec.Command(cmd, "-t", table, "-S").Output()
+		if errors.Is(err, exec.ErrNotFound) {
+			// without the command no rule was ever installed
+			return nil
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to list %s rules: %w", table, err)
+			}
+			continue
+		}
+
+		for _, line := range strings.Split(string(out), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || fields[0] != "-A" || !hasComment(fields, comment) {
+				continue
+			}
+			args := append([]string{"-t", table, "-D"}, unquote(fields[1:])...)
+			if err := xtables(cmd, args...); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if firstErr == nil {
+		logger.Log.Debug("removed iptables rules", zap.String("cmd", cmd), zap.String("comment", comment))
+	}
+	return firstErr
+}
+
+func hasComment(fields []string, comment string) bool {
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "--comment" && strings.Trim(fields[i+1], `"`) == comment {
+			return true
+		}
+	}
+	return false
+}
+
+func unquote(fields []string) []string {
+	out := make([]string, len(fields))
+	for i, f := range fields {
+		out[i] = strings.Trim(f, `"`)
+	}
+	return out
+}
+
+func xtables(cmd string, args ...string) error {
+	logger.Log.Debug(cmd, zap.Strings("args", args))
+	if output, err := exec.Command(cmd, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s: %w, output: %s", cmd, strings.Join(args, " "), err, string(output))
+	}
+	return nil
+}
+
+func writeSysctl(path, value string) error {
+	if err := os.WriteFile(path, []byte(value), 0o644); err != nil {
+		return fmt.Errorf("failed to set %s: %w", path, err)
+	}
+	return nil
+}
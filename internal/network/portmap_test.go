@@ -0,0 +1,72 @@
+package network
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/truongnhatanh7/xocker/internal/state"
+)
+
+func TestParsePortMapping(t *testing.T) {
+	tests := []struct {
+		spec string
+		want state.PortMapping
+		err  string // substring of the error, empty when valid
+	}{
+		{spec: "8080:80", want: state.PortMapping{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}},
+		{spec: "127.0.0.1:53:53/udp", want: state.PortMapping{HostIP: "127.0.0.1", HostPort: 53, ContainerPort: 53, Protocol: "udp"}},
+		{spec: "[::1]:8080:80", want: state.PortMapping{HostIP: "::1", HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}},
+		{spec: "[::]:8080:80/udp", want: state.PortMapping{HostIP: "::", HostPort: 8080, ContainerPort: 80, Protocol: "udp"}},
+		{spec: "::1:8080:80", err: "invalid port mapping"},
+		{spec: "fd00::1:8080:80", err: "invalid port mapping"},
+		{spec: "[127.0.0.1]:8080:80", err: "invalid IPv6 host IP"},
+		{spec: "[::1]8080:80", err: "invalid IPv6 host IP"},
+		{spec: "[::1]:1:8080:80", err: "invalid port mapping"},
+		{spec: "host:8080:80", err: "IPv6 addresses go in brackets"},
+		{spec: "80", err: "invalid port mapping"},
+		{spec: "0:80", err: "invalid host port"},
+		{spec: "8080:80/sctp", err: "invalid protocol"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParsePortMapping(tt.spec)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("ParsePortMapping() = %+v %v, want error %q", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("ParsePortMapping() = %+v %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPortRulesIPv6(t *testing.T) {
+	p := state.PortMapping{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}
+
+	v4 := portRules("c", "10.0.0.2", "br0", p)
+	v6 := portRules("c", "fd00::2", "br0", p)
+	if len(v6) != len(v4)-1 {
+		t.Fatalf("got %d IPv6 rules, want the %d IPv4 rules without the localhost one", len(v6), len(v4))
+	}
+	for _, r := range v6 {
+		args := strings.Join(r.args, " ")
+		if strings.Contains(args, "127.0.0.0/8") || strings.Contains(args, "10.0.0.2") {
+			t.Fatalf("IPv6 rule %s %s has an IPv4 address", r.chain, args)
+		}
+	}
+	if dnat := strings.Join(v6[0].args, " "); !strings.Contains(dnat, "--to-destination [fd00::2]:80") {
+		t.Fatalf("DNAT rule = %s, want the bracketed IPv6 destination", dnat)
+	}
+}
+
+func TestPortRulesUnspecifiedHostIP(t *testing.T) {
+	for _, hostIP := range []string{"0.0.0.0", "::"} {
+		dnat := strings.Join(portRules("c", "10.0.0.2", "br0", state.PortMapping{HostIP: hostIP, HostPort: 8080, ContainerPort: 80, Protocol: "tcp"})[0].args, " ")
+		if strings.Contains(dnat, "-d "+hostIP) || !strings.Contains(dnat, "--dst-type LOCAL") {
+			t.Fatalf("DNAT rule for %s = %s, want every local address", hostIP, dnat)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package network
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/truongnhatanh7/xocker/internal/logger"
+	"github.com/truongnhatanh7/xocker/internal/state"
+	"go.uber.org/zap"
+)
+
+const (
+	// how long a packet may wait in the tbf queue before it's dropped
+	tbfLatency = "50ms"
+	// the bucket must hold a few full sized frames even at low rates
+	minBurstBytes = 16 * 1024
+)
+
+// ShapeLink attaches the tc qdiscs enforcing s to the host side veth of a
+// container. Packets towards the container leave through the host veth, where
+// netem adds latency and loss and tbf limits the ingress rate. Packets from
+// the container enter through it and are policed to the egress rate. The
+// qdiscs go away with the veth.
+func ShapeLink(hostVeth string, s state.LinkShaping) error {
+	if s.IsZero() {
+		return nil
+	}
+
+	var cmds [][]string
+	parent := []string{"root"}
+	if s.Latency > 0 || s.Loss > 0 {
+		netem := []string{"qdisc", "add", "dev", hostVeth, "root", "handle", "1:", "netem"}
+		if s.Latency > 0 {
+			netem = append(netem, "delay", fmt.Sprintf("%dus", s.Latency.Microseconds()))
+		}
+		if s.Loss > 0 {
+			netem = append(netem, "loss", fmt.Sprintf("%g%%", s.Loss))
+		}
+		cmds = append(cmds, netem)
+		// the rate limit is chained below netem
+		parent = []string{"parent", "1:1"}
+	}
+	if s.IngressRate > 0 {
+		tbf := append([]string{"qdisc", "add", "dev", hostVeth}, parent...)
+		tbf = append(tbf, "handle", "10:", "tbf",
+			"rate", fmt.Sprintf("%dbit", s.IngressRate),
+			"burst", burst(s.IngressRate),
+			"latency", tbfLatency)
+		cmds = append(cmds, tbf)
+	}
+	if s.EgressRate > 0 {
+		cmds = append(cmds,
+			[]string{"qdisc", "add", "dev", hostVeth, "handle", "ffff:", "ingress"},
+			[]string{"filter", "add", "dev", hostVeth, "parent", "ffff:", "matchall",
+				"action", "police", "rate", fmt.Sprintf("%dbit", s.EgressRate), "burst", burst(s.EgressRate), "drop"})
+	}
+
+	for _, args := range cmds {
+		if err := tc(args...); err != nil {
+			return fmt.Errorf("failed to shape traffic on %s: %w", hostVeth, err)
+		}
+	}
+
+	logger.Log.Info("traffic shaping applied", zap.String("veth", hostVeth), zap.Stringer("shaping", s))
+	return nil
+}
+
+// burst sizes the token bucket to 10ms worth of traffic
+func burst(rate uint64) string {
+	bytes := rate / 8 / 100
+	if bytes < minBurstBytes {
+		bytes = minBurstBytes
+	}
+	return fmt.Sprintf("%db", bytes)
+}
+
+func tc(args ...string) error {
+	logger.Log.Debug("tc", zap.Strings("args", args))
+	if output, err := exec.Command("tc", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("tc %s: %w, output: %s", strings.Join(args, " "), err, string(output))
+	}
+	return nil
+}
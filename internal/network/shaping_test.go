@@ -0,0 +1,19 @@
+package network
+
+import "testing"
+
+func TestBurst(t *testing.T) {
+	tests := []struct {
+		rate uint64
+		want string
+	}{
+		{1_000_000, "16384b"}, // 10ms at 1mbit is below the minimum
+		{100_000_000, "125000b"},
+		{1_000_000_000, "1250000b"},
+	}
+	for _, tt := range tests {
+		if got := burst(tt.rate); got != tt.want {
+			t.Errorf("burst(%d) = %s, want %s", tt.rate, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/truongnhatanh7/xocker/internal/state"
+	"golang.org/x/sys/unix"
+)
+
+var NetworksDir = filepath.Join(state.RootDir, "networks")
+
+const (
+	networkFile = "network.json"
+
+	// DefaultNetwork is used by containers started without --network
+	DefaultNetwork = "xocker"
+	DriverBridge   = "bridge"
+)
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+type Network struct {
+	Name    string `json:"name"`
+	ID      string `json:"id"`
+	Driver  string `json:"driver"`
+	Bridge  string `json:"bridge"`
+	Subnet  string `json:"subnet"`
+	Gateway string `json:"gateway"`
+	// optional IPv6 subnet making the network dual-stack
+	SubnetV6  string    `json:"subnetV6,omitempty"`
+	GatewayV6 string    `json:"gatewayV6,omitempty"`
+	IPMasq    bool      `json:"ipMasq"`
+	Created   time.Time `json:"created"`
+}
+
+type CreateOptions struct {
+	Name string
+	// Subnet in CIDR notation, Gateway defaults to its first address
+	Subnet  string
+	Gateway string
+	// optional, the network stays IPv4 only without it
+	SubnetV6  string
+	GatewayV6 string
+	IPMasq    bool
+}
+
+func dir(name string) string {
+	return filepath.Join(NetworksDir, name)
+}
+
+func (n *Network) Dir() string {
+	return dir(n.Name)
+}
+
+// GatewayCIDR is the gateway address with the subnet prefix, assigned to the bridge
+func (n *Network) GatewayCIDR() string {
+	return gatewayCIDR(n.Gateway, n.Subnet)
+}
+
+// GatewayV6CIDR is GatewayCIDR of the IPv6 subnet, empty for IPv4 only networks
+func (n *Network) GatewayV6CIDR() string {
+	if n.SubnetV6 == "" {
+		return ""
+	}
+	return gatewayCIDR(n.GatewayV6, n.SubnetV6)
+}
+
+// HasIPv6 reports whether the network is dual-stack
+func (n *Network) HasIPv6() bool {
+	return n.SubnetV6 != ""
+}
+
+func gatewayCIDR(gateway, subnet string) string {
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return gateway
+	}
+	ones, _ := ipNet.Mask.Size()
+	return fmt.Sprintf("%s/%d", gateway, ones)
+}
+
+// Create persists a new bridge network, the bridge itself is set up when the
+// first container attaches to it
+func Create(opts CreateOptions) (*Network, error) {
+	if !validName.MatchString(opts.Name) {
+		return nil, fmt.Errorf("invalid network name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", opts.Name)
+	}
+	if opts.Name == "none" || opts.Name == "host" || opts.Name == DefaultNetwork {
+		return nil, fmt.Errorf("network name %s is reserved", opts.Name)
+	}
+	if _, err := Get(opts.Name); err == nil {
+		return nil, fmt.Errorf("network with name %s already exists", opts.Name)
+	}
+	if opts.Subnet == "" {
+		return nil, fmt.Errorf("a subnet is required")
+	}
+
+	ipNet, gwIP, err := parseSubnet(opts.Subnet, opts.Gateway, false)
+	if err != nil {
+		return nil, err
+	}
+	var ipNetV6 *net.IPNet
+	var gwIPV6 net.IP
+	if opts.SubnetV6 != "" {
+		if ipNetV6, gwIPV6, err = parseSubnet(opts.SubnetV6, opts.GatewayV6, true); err != nil {
+			return nil, err
+		}
+	} else if opts.GatewayV6 != "" {
+		return nil, fmt.Errorf("an IPv6 gateway requires an IPv6 subnet")
+	}
+
+	// the default network takes its subnet even if nothing used it yet
+	if _, err := EnsureDefault(); err != nil {
+		return nil, err
+	}
+	networks, err := List()
+	if err != nil {
+		return nil, err
+	}
+	for _, other := range networks {
+		if err := checkOverlap(ipNet, other, other.Subnet); err != nil {
+			return nil, err
+		}
+		if ipNetV6 != nil && other.SubnetV6 != "" {
+			if err := checkOverlap(ipNetV6, other, other.SubnetV6); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := checkHostRoutes(ipNet, unix.AF_INET); err != nil {
+		return nil, err
+	}
+	if ipNetV6 != nil {
+		if err := checkHostRoutes(ipNetV6, unix.AF_INET6); err != nil {
+			return nil, err
+		}
+	}
+
+	id := state.NewID()
+	n := &Network{
+		Name:    opts.Name,
+		ID:      id,
+		Driver:  DriverBridge,
+		Bridge:  "br-" + state.ShortID(id),
+		Subnet:  ipNet.String(),
+		Gateway: gwIP.String(),
+		IPMasq:  opts.IPMasq,
+		Created: time.Now(),
+	}
+	if ipNetV6 != nil {
+		n.SubnetV6 = ipNetV6.String()
+		n.GatewayV6 = gwIPV6.String()
+	}
+	if err := n.save(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// parseSubnet validates a subnet of the given family and its gateway, which
+// defaults to the first address of the subnet
+func parseSubnet(subnet, gateway string, v6 bool) (*net.IPNet, net.IP, error) {
+	ip, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid subnet %q: %w", subnet, err)
+	}
+	if v6 && ip.To4() != nil {
+		return nil, nil, fmt.Errorf("invalid IPv6 subnet %q: not an IPv6 subnet", subnet)
+	}
+	if !v6 && ip.To4() == nil {
+		return nil, nil, fmt.Errorf("invalid subnet %q: use --ipv6-subnet for IPv6", subnet)
+	}
+	if ones, bits := ipNet.Mask.Size(); bits-ones < 2 {
+		return nil, nil, fmt.Errorf("subnet %s is too small", ipNet)
+	}
+
+	if gateway == "" {
+		first := make(net.IP, len(ipNet.IP))
+		copy(first, ipNet.IP)
+		first[len(first)-1]++
+		gateway = first.String()
+	}
+	gwIP := net.ParseIP(gateway)
+	if gwIP == nil || !ipNet.Contains(gwIP) {
+		return nil, nil, fmt.Errorf("gateway %s is not in subnet %s", gateway, ipNet)
+	}
+	return ipNet, gwIP, nil
+}
+
+func checkOverlap(ipNet *net.IPNet, other *Network, otherSubnet string) error {
+	_, otherNet, err := net.ParseCIDR(otherSubnet)
+	if err != nil {
+		return nil
+	}
+	if otherNet.Contains(ipNet.IP) || ipNet.Contains(otherNet.IP) {
+		return fmt.Errorf("subnet %s overlaps with network %s (%s)", ipNet, other.Name, otherSubnet)
+	}
+	return nil
+}
+
+// checkHostRoutes rejects a subnet the host already routes, like the LAN it
+// is on, the bridge route would shadow it
+func checkHostRoutes(ipNet *net.IPNet, family uint8) error {
+	routes, err := RouteList(family)
+	if err != nil {
+		return fmt.Errorf("failed to list host routes: %w", err)
+	}
+	return checkRouteOverlap(ipNet, routes)
+}
+
+func checkRouteOverlap(ipNet *net.IPNet, routes []netip.Prefix) error {
+	addr, _ := netip.AddrFromSlice(ipNet.IP)
+	ones, _ := ipNet.Mask.Size()
+	prefix := netip.PrefixFrom(addr.Unmap(), ones)
+	for _, route := range routes {
+		if route.Overlaps(prefix) {
+			return fmt.Errorf("subnet %s overlaps with host route %s", ipNet, route)
+		}
+	}
+	return nil
+}
+
+// EnsureDefault creates the default network on xocker0 if it doesn't exist yet
+func EnsureDefault() (*Network, error) {
+	if n, err := Get(DefaultNetwork); err == nil {
+		return n, nil
+	}
+
+	n := &Network{
+		Name:    DefaultNetwork,
+		ID:      state.NewID(),
+		Driver:  DriverBridge,
+		Bridge:  "xocker0",
+		Subnet:  "172.18.0.0/24",
+		Gateway: "172.18.0.1",
+		IPMasq:  true,
+		Created: time.Now(),
+	}
+	if err := n.save(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (n *Network) save() error {
+	if err := os.MkdirAll(n.Dir(), 0o755); err != nil {
+		return fmt.Errorf("failed to create network dir: %w", err)
+	}
+
+	data, err := json.MarshalIndent(n, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(n.Dir(), networkFile), data, 0o644); err != nil {
+		return fmt.Errorf("failed to save network: %w", err)
+	}
+	return nil
+}
+
+func Get(name string) (*Network, error) {
+	data, err := os.ReadFile(filepath.Join(dir(name), networkFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such network: %s", name)
+		}
+		return nil, err
+	}
+
+	var n Network
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, fmt.Errorf("failed to parse network %s: %w", name, err)
+	}
+	return &n, nil
+}
+
+func List() ([]*Network, error) {
+	entries, err := os.ReadDir(NetworksDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var networks []*Network
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		n, err := Get(e.Name())
+		if err != nil {
+			continue
+		}
+		networks = append(networks, n)
+	}
+
+	sort.Slice(networks, func(i, j int) bool {
+		return networks[i].Name < networks[j].Name
+	})
+	return networks, nil
+}
+
+// Containers returns the running containers attached to the network
+func Containers(name string) ([]*state.State, error) {
+	states, err := state.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var attached []*state.State
+	for _, s := range states {
+		if s.Status != state.StatusExited && s.Network != nil && s.Network.Name == name {
+			attached = append(attached, s)
+		}
+	}
+	return attached, nil
+}
+
+// Remove tears down the bridge and its NAT rules and forgets the network, the
+// default network can't be removed
+func Remove(name string) error {
+	if name == DefaultNetwork {
+		return fmt.Errorf("the default network %s cannot be removed", name)
+	}
+	n, err := Get(name)
+	if err != nil {
+		return err
+	}
+
+	attached, err := Containers(name)
+	if err != nil {
+		return err
+	}
+	if len(attached) > 0 {
+		return fmt.Errorf("network %s has active endpoints, container %s is still attached", name, attached[0].Name)
+	}
+
+	StopDNS(n)
+	if err := TeardownBridge(n); err != nil {
+		return err
+	}
+	return os.RemoveAll(n.Dir())
+}
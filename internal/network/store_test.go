@@ -0,0 +1,49 @@
+package network
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestCreateReservesDefaultNetwork(t *testing.T) {
+	testNetwork(t, "", "", "", "")
+
+	if _, err := Create(CreateOptions{Name: DefaultNetwork, Subnet: "10.99.0.0/24"}); err == nil || !strings.Contains(err.Error(), "reserved") {
+		t.Fatalf("Create(%s) = %v, want reserved", DefaultNetwork, err)
+	}
+	// the default network doesn't exist yet but its subnet is still taken
+	_, err := Create(CreateOptions{Name: "clash", Subnet: "172.18.0.0/16"})
+	if err == nil || !strings.Contains(err.Error(), "network "+DefaultNetwork) {
+		t.Fatalf("Create(clash) = %v, want overlap with %s", err, DefaultNetwork)
+	}
+}
+
+func TestCheckRouteOverlap(t *testing.T) {
+	routes := []netip.Prefix{
+		netip.MustParsePrefix("192.168.1.0/24"),
+		netip.MustParsePrefix("fd00::/64"),
+	}
+	tests := []struct {
+		subnet  string
+		overlap bool
+	}{
+		{"192.168.1.0/24", true},
+		{"192.168.1.128/25", true},
+		{"192.168.0.0/16", true},
+		{"192.168.2.0/24", false},
+		{"10.0.0.0/8", false},
+		{"fd00::/80", true},
+		{"fd00:1::/64", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subnet, func(t *testing.T) {
+			_, ipNet, _ := net.ParseCIDR(tt.subnet)
+			err := checkRouteOverlap(ipNet, routes)
+			if (err != nil) != tt.overlap {
+				t.Fatalf("checkRouteOverlap() = %v, want overlap %v", err, tt.overlap)
+			}
+		})
+	}
+}
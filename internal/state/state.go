@@ -0,0 +1,304 @@
+package state
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	RootDir       = "/var/lib/xocker"
+	ContainersDir = filepath.Join(RootDir, "containers")
+)
+
+const (
+	StatusCreated = "created"
+	StatusRunning = "running"
+	StatusExited  = "exited"
+
+	stateFile  = "state.json"
+	ShortIDLen = 12
+
+	// guards container names, see Create
+	namesLockFile = ".names.lock"
+)
+
+type PortMapping struct {
+	HostIP        string `json:"hostIP,omitempty"`
+	HostPort      uint16 `json:"hostPort"`
+	ContainerPort uint16 `json:"containerPort"`
+	Protocol      string `json:"protocol"`
+}
+
+func (p PortMapping) String() string {
+	host := fmt.Sprintf("%d", p.HostPort)
+	if p.HostIP != "" {
+		host = net.JoinHostPort(p.HostIP, host)
+	}
+	return fmt.Sprintf("%s->%d/%s", host, p.ContainerPort, p.Protocol)
+}
+
+// LinkShaping limits the traffic of a container on its host side veth, zero
+// values mean unlimited
+type LinkShaping struct {
+	// bits per second towards the container and from it
+	IngressRate uint64        `json:"ingressRate,omitempty"`
+	EgressRate  uint64        `json:"egressRate,omitempty"`
+	Latency     time.Duration `json:"latency,omitempty"`
+	// percentage of the packets towards the container that are dropped
+	Loss float64 `json:"loss,omitempty"`
+}
+
+func (s LinkShaping) IsZero() bool {
+	return s == LinkShaping{}
+}
+
+func (s LinkShaping) String() string {
+	var parts []string
+	if s.IngressRate > 0 {
+		parts = append(parts, "ingress "+formatRate(s.IngressRate))
+	}
+	if s.EgressRate > 0 {
+		parts = append(parts, "egress "+formatRate(s.EgressRate))
+	}
+	if s.Latency > 0 {
+		parts = append(parts, "latency "+s.Latency.String())
+	}
+	if s.Loss > 0 {
+		parts = append(parts, fmt.Sprintf("loss %g%%", s.Loss))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatRate prints bits per second with the largest tc unit dividing them
+func formatRate(bits uint64) string {
+	units := []string{"bit", "kbit", "mbit", "gbit"}
+	i := 0
+	for i < len(units)-1 && bits >= 1000 && bits%1000 == 0 {
+		bits /= 1000
+		i++
+	}
+	return fmt.Sprintf("%d%s", bits, units[i])
+}
+
+type NetworkState struct {
+	Name     string        `json:"name"`
+	IP       string        `json:"ip"`
+	Gateway  string        `json:"gateway"`
+	Bridge   string        `json:"bridge"`
+	Veth     string        `json:"veth"`
+	HostVeth string        `json:"hostVeth"`
+	Ports    []PortMapping `json:"ports,omitempty"`
+	// set on dual-stack networks only
+	IPv6      string `json:"ipv6,omitempty"`
+	GatewayV6 string `json:"gatewayV6,omitempty"`
+	// extra names resolved by the embedded DNS of the network
+	Aliases []string     `json:"aliases,omitempty"`
+	Shaping *LinkShaping `json:"shaping,omitempty"`
+}
+
+const (
+	MountTypeBind   = "bind"
+	MountTypeVolume = "volume"
+	MountTypeTmpfs  = "tmpfs"
+)
+
+type Mount struct {
+	Type string `json:"type"`
+	// Name of the volume, Source is then the volume's data dir
+	Name     string `json:"name,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"readOnly"`
+	// tmpfs only
+	TmpfsSize uint64 `json:"tmpfsSize,omitempty"`
+	TmpfsMode uint32 `json:"tmpfsMode,omitempty"`
+}
+
+type CgroupState struct {
+	// systemd or cgroupfs, empty for containers started before drivers existed
+	Driver string `json:"driver,omitempty"`
+	Path   string `json:"path"`
+	Unit   string `json:"unit"`
+}
+
+type State struct {
+	ID            string        `json:"id"`
+	Name          string        `json:"name"`
+	Status        string        `json:"status"`
+	Pid           int           `json:"pid"`
+	SupervisorPid int           `json:"supervisorPid"` // xocker process waiting on the container (CLI or shim)
+	Cmd           string        `json:"cmd"`
+	Args          []string      `json:"args"`
+	RootFS        string        `json:"rootfs,omitempty"`
+	Image         string        `json:"image,omitempty"`
+	Created       time.Time     `json:"created"`
+	StartedAt     time.Time     `json:"startedAt"`
+	FinishedAt    time.Time     `json:"finishedAt"`
+	ExitCode      int           `json:"exitCode"`
+	OOMKilled     bool          `json:"oomKilled"`
+	LogPath       string        `json:"logPath"`
+	Mounts        []Mount       `json:"mounts,omitempty"`
+	Network       *NetworkState `json:"network,omitempty"`
+	Cgroup        *CgroupState  `json:"cgroup,omitempty"`
+
+	// start times of Pid and SupervisorPid in clock ticks since boot, they
+	// tell the processes apart from later ones reusing their PIDs
+	PidStartTime        uint64 `json:"pidStartTime,omitempty"`
+	SupervisorStartTime uint64 `json:"supervisorStartTime,omitempty"`
+}
+
+func NewID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func ShortID(id string) string {
+	if len(id) > ShortIDLen {
+		return id[:ShortIDLen]
+	}
+	return id
+}
+
+func Dir(id string) string {
+	return filepath.Join(ContainersDir, id)
+}
+
+func (s *State) Save() error {
+	dir := Dir(s.ID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create state dir %s: %w", dir, err)
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	// write to a temp file then rename, so readers never see a partial file
+	tmp := filepath.Join(dir, stateFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	return os.Rename(tmp, filepath.Join(dir, stateFile))
+}
+
+func Load(id string) (*State, error) {
+	data, err := os.ReadFile(filepath.Join(Dir(id), stateFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse state of %s: %w", id, err)
+	}
+	return &s, nil
+}
+
+// List returns all known containers, newest first.
+func List() ([]*State, error) {
+	entries, err := os.ReadDir(ContainersDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var states []*State
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		s, err := Load(e.Name())
+		if err != nil {
+			// container dir without a readable state file, skip it
+			continue
+		}
+		states = append(states, s)
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Created.After(states[j].Created)
+	})
+	return states, nil
+}
+
+// Find resolves a container by full ID, name or unique ID prefix.
+func Find(ref string) (*State, error) {
+	if ref == "" {
+		return nil, fmt.Errorf("empty container reference")
+	}
+
+	states, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []*State
+	for _, s := range states {
+		if s.ID == ref || s.Name == ref {
+			return s, nil
+		}
+		if strings.HasPrefix(s.ID, ref) {
+			matches = append(matches, s)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no such container: %s", ref)
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("multiple containers match prefix %s", ref)
+	}
+}
+
+// Create saves the state of a new container, failing if another container
+// has its name. The check and the write happen under an exclusive flock so two
+// concurrent runs can't both take the same name.
+func (s *State) Create() error {
+	if err := os.MkdirAll(ContainersDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", ContainersDir, err)
+	}
+
+	lock, err := os.OpenFile(filepath.Join(ContainersDir, namesLockFile), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open name lock: %w", err)
+	}
+	defer lock.Close()
+	if err := unix.Flock(int(lock.Fd()), unix.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock container names: %w", err)
+	}
+	defer unix.Flock(int(lock.Fd()), unix.LOCK_UN)
+
+	states, err := List()
+	if err != nil {
+		return err
+	}
+	for _, other := range states {
+		// a detached container is created by the CLI, then again by its shim
+		if other.Name == s.Name && other.ID != s.ID {
+			return fmt.Errorf("container name %q is already in use", s.Name)
+		}
+	}
+	return s.Save()
+}
+
+func Remove(id string) error {
+	return os.RemoveAll(Dir(id))
+}
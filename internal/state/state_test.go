@@ -0,0 +1,85 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func testContainersDir(t *testing.T) {
+	t.Helper()
+	containersDir := ContainersDir
+	ContainersDir = t.TempDir()
+	t.Cleanup(func() {
+		ContainersDir = containersDir
+	})
+}
+
+func TestCreateRejectsNameInUse(t *testing.T) {
+	testContainersDir(t)
+
+	if err := (&State{ID: "a", Name: "web"}).Create(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&State{ID: "b", Name: "web"}).Create(); err == nil {
+		t.Fatal("Create() took a name in use")
+	}
+	// the shim creates the state the CLI already created
+	if err := (&State{ID: "a", Name: "web", Status: StatusRunning}).Create(); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := Load("a"); err != nil || st.Status != StatusRunning {
+		t.Fatalf("Load() = %v %v, want the recreated state", st, err)
+	}
+}
+
+func TestCreateWaitsForNameLock(t *testing.T) {
+	testContainersDir(t)
+
+	lock, err := os.OpenFile(filepath.Join(ContainersDir, namesLockFile), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Close()
+	if err := unix.Flock(int(lock.Fd()), unix.LOCK_EX); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- (&State{ID: "a", Name: "web"}).Create()
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Create() = %v while another run holds the name lock", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := unix.Flock(int(lock.Fd()), unix.LOCK_UN); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLinkShapingString(t *testing.T) {
+	tests := []struct {
+		s    LinkShaping
+		want string
+	}{
+		{LinkShaping{}, ""},
+		{LinkShaping{IngressRate: 10_000_000}, "ingress 10mbit"},
+		{LinkShaping{EgressRate: 1_500_000}, "egress 1500kbit"},
+		{LinkShaping{IngressRate: 999, Latency: 50 * time.Millisecond, Loss: 0.5}, "ingress 999bit, latency 50ms, loss 0.5%"},
+		{LinkShaping{EgressRate: 2_000_000_000_000}, "egress 2000gbit"},
+	}
+	for _, tt := range tests {
+		if got := tt.s.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
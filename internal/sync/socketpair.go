@@ -10,7 +10,9 @@ import (
 )
 
 func CreateSocketPair() (*os.File, *os.File, error) {
-	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	// close on exec, a child only gets the end passed in its ExtraFiles so the
+	// other one sees EOF once the parent closes its end
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create socketpair: %w", err)
 	}
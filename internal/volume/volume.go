@@ -0,0 +1,143 @@
+package volume
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/truongnhatanh7/xocker/internal/state"
+)
+
+var VolumesDir = filepath.Join(state.RootDir, "volumes")
+
+const volumeFile = "volume.json"
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+type Volume struct {
+	Name       string    `json:"name"`
+	Driver     string    `json:"driver"`
+	Mountpoint string    `json:"mountpoint"`
+	Created    time.Time `json:"created"`
+}
+
+func dir(name string) string {
+	return filepath.Join(VolumesDir, name)
+}
+
+// Create makes a new named volume, creating an existing one returns it as is
+func Create(name string) (*Volume, error) {
+	if name == "" {
+		name = state.NewID()
+	}
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("invalid volume name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+
+	if v, err := Get(name); err == nil {
+		return v, nil
+	}
+
+	v := &Volume{
+		Name:       name,
+		Driver:     "local",
+		Mountpoint: filepath.Join(dir(name), "_data"),
+		Created:    time.Now(),
+	}
+	if err := os.MkdirAll(v.Mountpoint, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create volume dir: %w", err)
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir(name), volumeFile), data, 0o644); err != nil {
+		return nil, fmt.Errorf("failed to save volume: %w", err)
+	}
+	return v, nil
+}
+
+func Get(name string) (*Volume, error) {
+	data, err := os.ReadFile(filepath.Join(dir(name), volumeFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such volume: %s", name)
+		}
+		return nil, err
+	}
+
+	var v Volume
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("failed to parse volume %s: %w", name, err)
+	}
+	return &v, nil
+}
+
+func List() ([]*Volume, error) {
+	entries, err := os.ReadDir(VolumesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var volumes []*Volume
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		v, err := Get(e.Name())
+		if err != nil {
+			continue
+		}
+		volumes = append(volumes, v)
+	}
+
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].Name < volumes[j].Name
+	})
+	return volumes, nil
+}
+
+// UsedBy returns the containers (running or not) that mount the volume
+func UsedBy(name string) ([]*state.State, error) {
+	states, err := state.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var users []*state.State
+	for _, s := range states {
+		for _, m := range s.Mounts {
+			if m.Type == state.MountTypeVolume && m.Name == name {
+				users = append(users, s)
+				break
+			}
+		}
+	}
+	return users, nil
+}
+
+// Remove deletes a volume and its data, a volume still referenced by a
+// container is only removed with force
+func Remove(name string, force bool) error {
+	if _, err := Get(name); err != nil {
+		return err
+	}
+
+	users, err := UsedBy(name)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 && !force {
+		return fmt.Errorf("volume %s is in use by container %s", name, users[0].Name)
+	}
+
+	return os.RemoveAll(dir(name))
+}